@@ -0,0 +1,301 @@
+package tgmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Telegram limits of inline keyboards.
+const (
+	// ButtonRowLimit is the most buttons a keyboard row can hold.
+	ButtonRowLimit = 8
+	// ButtonLimit is the most buttons a keyboard can hold.
+	ButtonLimit = 100
+	// CallbackDataLimit is the longest callback data, in bytes.
+	CallbackDataLimit = 64
+)
+
+// callbackPrefix starts the destination of buttons that send callback data
+// to the bot instead of opening a URL, e.g. "callback:vote_up".
+const callbackPrefix = "callback:"
+
+// InlineKeyboardMarkup is the Bot API inline keyboard of a message, built
+// from the buttons of a document with WithButtons. It encodes to the JSON
+// of the reply_markup parameter.
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton is a button of an inline keyboard, opening URL or
+// sending CallbackData.
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+	// Position is where the button starts in the source.
+	Position Position `json:"-"`
+}
+
+// KeyboardError is returned for a keyboard Telegram would reject.
+type KeyboardError struct {
+	Diagnostics []Diagnostic
+}
+
+// Error implements error.
+func (e *KeyboardError) Error() string {
+	var b strings.Builder
+	b.WriteString("tgmd: invalid keyboard: ")
+	b.WriteString(e.Diagnostics[0].String())
+	if more := len(e.Diagnostics) - 1; more > 0 {
+		fmt.Fprintf(&b, " (and %d more)", more)
+	}
+	return b.String()
+}
+
+// Validate returns a *KeyboardError when Telegram would reject k: buttons
+// without text, URLs other than http, https and tg ones, callback data
+// over CallbackDataLimit, or more buttons than ButtonRowLimit in a row or
+// ButtonLimit in all.
+func (k *InlineKeyboardMarkup) Validate() error {
+	var diagnostics []Diagnostic
+	report := func(b InlineKeyboardButton, format string, args ...any) {
+		diagnostics = append(diagnostics, Diagnostic{Position: b.Position, Message: fmt.Sprintf(format, args...)})
+	}
+	count := 0
+	for _, row := range k.InlineKeyboard {
+		for i, b := range row {
+			count++
+			if i == ButtonRowLimit {
+				report(b, "row has %d buttons, over the limit of %d", len(row), ButtonRowLimit)
+			}
+			if count == ButtonLimit+1 {
+				report(b, "keyboard has more than %d buttons", ButtonLimit)
+			}
+			if strings.TrimSpace(b.Text) == "" {
+				report(b, "button has no text")
+			}
+			switch {
+			case b.URL != "" && b.CallbackData != "":
+				report(b, "button %q has both a URL and callback data", b.Text)
+			case b.URL != "":
+				if u, err := url.Parse(b.URL); err != nil || !isButtonScheme(u.Scheme) {
+					report(b, "button %q URL %q must be an http, https or tg URL", b.Text, b.URL)
+				}
+			case len(b.CallbackData) > CallbackDataLimit:
+				report(b, "button %q callback data is %d bytes, over the limit of %d",
+					b.Text, len(b.CallbackData), CallbackDataLimit)
+			case b.CallbackData == "":
+				report(b, "button %q has neither a URL nor callback data", b.Text)
+			}
+		}
+	}
+	if len(diagnostics) > 0 {
+		return &KeyboardError{Diagnostics: diagnostics}
+	}
+	return nil
+}
+
+// isButtonScheme reports whether buttons can open URLs of scheme.
+func isButtonScheme(scheme string) bool {
+	switch strings.ToLower(scheme) {
+	case "http", "https", "tg":
+		return true
+	}
+	return false
+}
+
+// keyboardKey holds the *InlineKeyboardMarkup collected while parsing.
+var keyboardKey = parser.NewContextKey()
+
+// buttonsMarker matches the HTML comment introducing a list of buttons.
+var buttonsMarker = regexp.MustCompile(`(?i)^\s*<!--\s*buttons\s*-->\s*$`)
+
+// inlineButtonEnd follows the link of an inline button.
+const inlineButtonEnd = "]{.button}"
+
+type buttonCollector struct{}
+
+// Transform removes buttons from doc and stores them in pc: the list after
+// a "<!-- buttons -->" comment, one row per item, and links written as
+// "[[text](url)]{.button}", one row per line.
+func (t *buttonCollector) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var (
+		rows    [][]InlineKeyboardButton
+		blocks  []ast.Node
+		inlines []*ast.Link
+		line    = -1
+	)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.HTMLBlock:
+			list, ok := n.NextSibling().(*ast.List)
+			if !ok || !buttonsMarker.Match(rawHTMLContent(source, n)) {
+				return ast.WalkContinue, nil
+			}
+			for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+				if row := buttonRow(source, item); len(row) > 0 {
+					rows = append(rows, row)
+				}
+			}
+			blocks = append(blocks, n, list)
+			line = -1
+		case *ast.List:
+			if len(blocks) > 0 && blocks[len(blocks)-1] == n {
+				// already collected with its marker
+				return ast.WalkSkipChildren, nil
+			}
+		case *ast.Link:
+			if !isInlineButton(source, n) {
+				return ast.WalkContinue, nil
+			}
+			before := n.PreviousSibling().(*ast.Text)
+			b := newButton(source, n, before.Segment.Stop-1)
+			if b.Position.Line != line || len(rows) == 0 {
+				rows = append(rows, nil)
+			}
+			rows[len(rows)-1] = append(rows[len(rows)-1], b)
+			line = b.Position.Line
+			inlines = append(inlines, n)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(rows) == 0 {
+		return
+	}
+
+	for _, n := range inlines {
+		removeInlineButton(source, n)
+	}
+	for _, n := range blocks {
+		n.Parent().RemoveChild(n.Parent(), n)
+	}
+	pc.Set(keyboardKey, &InlineKeyboardMarkup{InlineKeyboard: rows})
+}
+
+// buttonRow returns the buttons of the links in list item n.
+func buttonRow(source []byte, n ast.Node) []InlineKeyboardButton {
+	var row []InlineKeyboardButton
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := c.(*ast.Link); ok && entering {
+			row = append(row, newButton(source, link, nodeOffset(source, link)))
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return row
+}
+
+// newButton returns the button of link n, written at offset.
+func newButton(source []byte, n *ast.Link, offset int) InlineKeyboardButton {
+	b := InlineKeyboardButton{
+		Text:     string(bytes.TrimSpace(plainText(source, n))),
+		Position: positionAt(source, offset),
+	}
+	if data, ok := bytes.CutPrefix(n.Destination, []byte(callbackPrefix)); ok {
+		b.CallbackData = string(data)
+	} else {
+		b.URL = string(n.Destination)
+	}
+	return b
+}
+
+// isInlineButton reports whether link n is written "[[text](url)]{.button}":
+// the text before it ends with '[' and the text after it starts with
+// inlineButtonEnd.
+func isInlineButton(source []byte, n *ast.Link) bool {
+	before, ok := n.PreviousSibling().(*ast.Text)
+	if !ok || !bytes.HasSuffix(before.Segment.Value(source), []byte{OpenBracketChar.Byte()}) {
+		return false
+	}
+	after, ok := n.NextSibling().(*ast.Text)
+	return ok && bytes.HasPrefix(source[after.Segment.Start:], []byte(inlineButtonEnd))
+}
+
+// removeInlineButton removes link n with the brackets and space around it,
+// and the line break it leaves at the end of its paragraph.
+func removeInlineButton(source []byte, n *ast.Link) {
+	before := n.PreviousSibling().(*ast.Text)
+	before.Segment = before.Segment.WithStop(before.Segment.Stop - 1)
+	rest := len(inlineButtonEnd)
+	end := n.NextSibling().(*ast.Text).Segment.Start + rest
+	if before.Segment.Len() > 0 && source[before.Segment.Stop-1] == SpaceChar.Byte() &&
+		end < len(source) && source[end] == SpaceChar.Byte() {
+		// One space is enough between the words around the button.
+		rest++
+	}
+	if before.Segment.Len() == 0 {
+		before.Parent().RemoveChild(before.Parent(), before)
+	}
+	cutText(n.NextSibling(), rest)
+
+	block := blockOf(n)
+	removeInline(source, n)
+	trimTrailingBreaks(source, block)
+}
+
+// cutText removes size bytes from the start of text n and the texts
+// following it, dropping the texts left empty.
+func cutText(n ast.Node, size int) {
+	for c := n; size > 0 && c != nil; {
+		t, ok := c.(*ast.Text)
+		if !ok {
+			break
+		}
+		cut := min(size, t.Segment.Len())
+		t.Segment = t.Segment.WithStart(t.Segment.Start + cut)
+		size -= cut
+		next := c.NextSibling()
+		if t.Segment.Len() == 0 && !t.SoftLineBreak() && !t.HardLineBreak() {
+			c.Parent().RemoveChild(c.Parent(), c)
+		}
+		c = next
+	}
+}
+
+// trimTrailingBreaks removes the blank text and line break left at the end
+// of block by removed inline nodes.
+func trimTrailingBreaks(source []byte, block ast.Node) {
+	if block.Parent() == nil {
+		return
+	}
+	for {
+		t, ok := block.LastChild().(*ast.Text)
+		if !ok {
+			return
+		}
+		if len(bytes.TrimSpace(t.Segment.Value(source))) > 0 {
+			t.Segment = t.Segment.TrimRightSpace(source)
+			t.SetSoftLineBreak(false)
+			t.SetHardLineBreak(false)
+			return
+		}
+		block.RemoveChild(block, t)
+	}
+}
+
+type buttonCollection struct{}
+
+// collectButtons removes buttons from the document with WithButtons.
+var collectButtons = &buttonCollection{}
+
+// Extend ...
+func (e *buttonCollection) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		// Before losses are reported for the comment introducing buttons.
+		util.Prioritized(&buttonCollector{}, 50),
+	))
+}
@@ -43,29 +43,30 @@ func (st SpecialTag) Bytes() []byte {
 
 // define characters.
 const (
-	UnderscoreChar   SpecialChar = '_'
-	AsteriskChar     SpecialChar = '*'
-	OpenBracketChar  SpecialChar = '['
-	CloseBracketChar SpecialChar = ']'
-	OpenParenChar    SpecialChar = '('
-	CloseParenChar   SpecialChar = ')'
-	OpenBraceChar    SpecialChar = '{'
-	CloseBraceChar   SpecialChar = '}'
-	HashChar         SpecialChar = '#'
-	PlusChar         SpecialChar = '+'
-	MinusChar        SpecialChar = '-'
-	EqualChar        SpecialChar = '='
-	DotChar          SpecialChar = '.'
-	TildeChar        SpecialChar = '~'
-	PipeChar         SpecialChar = '|'
-	ExclamationChar  SpecialChar = '!'
-	GreaterThanChar  SpecialChar = '>'
-	LessThanChar     SpecialChar = '<'
-	BackqouteChar    SpecialChar = '`'
-	SpaceChar        SpecialChar = ' '
-	NewLineChar      SpecialChar = '\n'
-	SlashChar        SpecialChar = '\\'
-	TabChar          SpecialChar = '\t'
+	UnderscoreChar     SpecialChar = '_'
+	AsteriskChar       SpecialChar = '*'
+	OpenBracketChar    SpecialChar = '['
+	CloseBracketChar   SpecialChar = ']'
+	OpenParenChar      SpecialChar = '('
+	CloseParenChar     SpecialChar = ')'
+	OpenBraceChar      SpecialChar = '{'
+	CloseBraceChar     SpecialChar = '}'
+	HashChar           SpecialChar = '#'
+	PlusChar           SpecialChar = '+'
+	MinusChar          SpecialChar = '-'
+	EqualChar          SpecialChar = '='
+	DotChar            SpecialChar = '.'
+	TildeChar          SpecialChar = '~'
+	PipeChar           SpecialChar = '|'
+	ExclamationChar    SpecialChar = '!'
+	GreaterThanChar    SpecialChar = '>'
+	LessThanChar       SpecialChar = '<'
+	BackqouteChar      SpecialChar = '`'
+	SpaceChar          SpecialChar = ' '
+	NewLineChar        SpecialChar = '\n'
+	SlashChar          SpecialChar = '\\'
+	TabChar            SpecialChar = '\t'
+	CarriageReturnChar SpecialChar = '\r'
 )
 
 // define symbols.
@@ -73,6 +74,13 @@ const (
 	CircleSymbol   SpecialRune = '•'
 	TriangleSymbol SpecialRune = '⁃'
 	SquareSymbol   SpecialRune = '‣'
+
+	UncheckedSymbol SpecialRune = '☐'
+	CheckedSymbol   SpecialRune = '☑'
+
+	ImageSymbol SpecialRune = '🖼'
+
+	CustomEmojiSymbol SpecialRune = '⭐'
 )
 
 // define Telegram Markdown formatting tags.
@@ -109,3 +117,23 @@ var escape = map[byte][]byte{
 	BackqouteChar.Byte():    BackqouteChar.Escaped(),
 	SlashChar.Byte():        SlashChar.Escaped(),
 }
+
+// define escape map for the contents of pre and code entities.
+var codeEscape = map[byte][]byte{
+	BackqouteChar.Byte(): BackqouteChar.Escaped(),
+	SlashChar.Byte():     SlashChar.Escaped(),
+}
+
+// define escape map for the (...) part of inline links.
+var linkEscape = map[byte][]byte{
+	CloseParenChar.Byte(): CloseParenChar.Escaped(),
+	SlashChar.Byte():      SlashChar.Escaped(),
+}
+
+// define HTML escape map.
+var htmlEscape = map[byte][]byte{
+	'&': []byte("&amp;"),
+	'<': []byte("&lt;"),
+	'>': []byte("&gt;"),
+	'"': []byte("&quot;"),
+}
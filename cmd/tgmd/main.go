@@ -0,0 +1,426 @@
+// Command tgmd converts Markdown to Telegram MarkdownV2, HTML, text with
+// entities or Telegraph page content, and checks existing MarkdownV2.
+//
+// Usage:
+//
+//	tgmd [flags] [file ...]
+//
+// Files are read in order; with no files, or "-", the input is read from
+// standard input. Run "tgmd -h" for the list of flags.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+// Output formats.
+const (
+	formatMarkdownV2 = "mdv2"
+	formatHTML       = "html"
+	formatEntities   = "entities"
+	formatTelegraph  = "telegraph"
+)
+
+// exit codes.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+var headingStyles = map[string]tgmd.SpecialTag{
+	"bold":          tgmd.BoldTg,
+	"italic":        tgmd.ItalicsTg,
+	"underline":     tgmd.UnderlineTg,
+	"strikethrough": tgmd.StrikethroughTg,
+	"spoiler":       tgmd.HiddenTg,
+	"none":          nil,
+}
+
+var numberFormats = map[string]tgmd.NumberFormat{
+	"dot":    tgmd.NumberDot,
+	"paren":  tgmd.NumberParen,
+	"roman":  tgmd.NumberRoman,
+	"keycap": tgmd.NumberKeycap,
+}
+
+var tableStyles = map[string]tgmd.TableStyle{
+	"monospace": tgmd.TableMonospace,
+	"list":      tgmd.TableList,
+}
+
+var underlineSyntaxes = map[string]tgmd.UnderlineSyntax{
+	"plus":       tgmd.UnderlinePlus,
+	"underscore": tgmd.UnderlineUnderscore,
+}
+
+var imageModes = map[string]tgmd.ImageMode{
+	"link":        tgmd.ImageLink,
+	"placeholder": tgmd.ImagePlaceholder,
+	"collect":     tgmd.ImageCollect,
+}
+
+var htmlPolicies = map[string]tgmd.HTMLPolicy{
+	"strip":     tgmd.HTMLStrip,
+	"escape":    tgmd.HTMLEscape,
+	"translate": tgmd.HTMLTranslate,
+}
+
+// heading holds the flags of one heading level.
+type heading struct {
+	style, prefix, postfix string
+}
+
+// options holds the parsed command line.
+type options struct {
+	format      string
+	output      string
+	split       int
+	validate    bool
+	strict      bool
+	headings    [6]heading
+	bullets     string
+	numbers     string
+	checkboxes  string
+	table       string
+	underline   string
+	images      string
+	divider     string
+	html        string
+	quote       bool
+	expandable  bool
+	frontMatter bool
+	hashtags    string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var o options
+	fs := newFlagSet(&o, stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	tgOpts, err := o.tgmdOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, "tgmd:", err)
+		return exitUsage
+	}
+	if !slices.Contains([]string{formatMarkdownV2, formatHTML, formatEntities, formatTelegraph}, o.format) {
+		fmt.Fprintf(stderr, "tgmd: invalid --format %q, want mdv2, html, entities or telegraph\n", o.format)
+		return exitUsage
+	}
+	if o.split > 0 && o.format != formatMarkdownV2 {
+		fmt.Fprintln(stderr, "tgmd: --split needs the mdv2 format")
+		return exitUsage
+	}
+
+	out := stdout
+	if o.output != "" {
+		f, err := os.Create(o.output)
+		if err != nil {
+			fmt.Fprintln(stderr, "tgmd:", err)
+			return exitFailure
+		}
+		defer f.Close()
+		out = f
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	code := exitOK
+	for _, name := range files {
+		input, err := readInput(name, stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, "tgmd:", err)
+			return exitFailure
+		}
+		if name == "-" {
+			name = "<stdin>"
+		}
+		if o.validate {
+			if !lint(stderr, name, input) {
+				code = exitFailure
+			}
+			continue
+		}
+		if err := convert(out, input, o, tgOpts); err != nil {
+			var strictErr *tgmd.StrictError
+			if errors.As(err, &strictErr) {
+				for _, loss := range strictErr.Losses {
+					fmt.Fprintf(stderr, "%s:%s\n", name, loss)
+				}
+				code = exitFailure
+				continue
+			}
+			fmt.Fprintf(stderr, "tgmd: %s: %v\n", name, err)
+			return exitFailure
+		}
+	}
+	return code
+}
+
+func newFlagSet(o *options, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("tgmd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: tgmd [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.format, "format", formatMarkdownV2, "output `format`: mdv2, html, entities (JSON) or telegraph (JSON nodes)")
+	fs.StringVar(&o.output, "o", "", "write the output to `file` instead of standard output")
+	fs.IntVar(&o.split, "split", 0, "split MarkdownV2 into messages of at most `n` characters, written as JSON lines")
+	fs.BoolVar(&o.validate, "validate", false, "check that the input is valid MarkdownV2 instead of converting it")
+	fs.BoolVar(&o.strict, "strict", false, "fail listing what Telegram cannot show as written, e.g. headings and tables")
+	for i := range o.headings {
+		h := &o.headings[i]
+		fs.StringVar(&h.style, fmt.Sprintf("h%d-style", i+1), "",
+			fmt.Sprintf("`style` of level %d headings: %s", i+1, choices(headingStyles)))
+		fs.StringVar(&h.prefix, fmt.Sprintf("h%d-prefix", i+1), "", fmt.Sprintf("text written before level %d headings", i+1))
+		fs.StringVar(&h.postfix, fmt.Sprintf("h%d-postfix", i+1), "", fmt.Sprintf("text written after level %d headings", i+1))
+	}
+	fs.StringVar(&o.bullets, "bullets", "", "list `bullets` by nesting level, up to three characters, e.g. \"•‣⁃\"")
+	fs.StringVar(&o.numbers, "numbers", "", "ordered list `format`: "+choices(numberFormats))
+	fs.StringVar(&o.checkboxes, "checkboxes", "", "unchecked and checked task list `glyphs`, e.g. \"☐☑\"")
+	fs.StringVar(&o.table, "table-style", "", "table `style`: "+choices(tableStyles))
+	fs.StringVar(&o.underline, "underline", "", "underline `syntax`: "+choices(underlineSyntaxes))
+	fs.StringVar(&o.images, "images", "", "image `mode`: "+choices(imageModes))
+	fs.StringVar(&o.divider, "divider", "", "`text` written for thematic breaks")
+	fs.StringVar(&o.html, "html", "", "raw HTML `policy`: "+choices(htmlPolicies))
+	fs.BoolVar(&o.quote, "quote", false, "quote the whole document")
+	fs.BoolVar(&o.expandable, "expandable", false, "make the document quote expandable (implies --quote)")
+	fs.BoolVar(&o.frontMatter, "front-matter", false, "read settings from the YAML or TOML front matter of the input")
+	fs.StringVar(&o.hashtags, "hashtags", "", "comma separated `tags` ending the message")
+	return fs
+}
+
+// choices lists the keys of m for a flag description.
+func choices[T any](m map[string]T) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, ", ")
+}
+
+// choice looks up the value of flag name in m.
+func choice[T any](name, value string, m map[string]T) (T, error) {
+	v, ok := m[value]
+	if !ok {
+		return v, fmt.Errorf("invalid --%s %q, want one of %s", name, value, choices(m))
+	}
+	return v, nil
+}
+
+// tgmdOptions turns the flags that were set into tgmd options.
+func (o *options) tgmdOptions() ([]tgmd.Option, error) {
+	var opts []tgmd.Option
+	defaults := tgmd.DefaultConfig()
+	for i, h := range o.headings {
+		if h == (heading{}) {
+			continue
+		}
+		e := defaults.Heading(i + 1)
+		if h.style != "" {
+			style, err := choice(fmt.Sprintf("h%d-style", i+1), h.style, headingStyles)
+			if err != nil {
+				return nil, err
+			}
+			e.Style = style
+		}
+		e.Prefix, e.Postfix = h.prefix, h.postfix
+		opts = append(opts, headingOption(i+1, e))
+	}
+
+	if o.bullets != "" {
+		bullets := []rune(o.bullets)
+		if len(bullets) > 3 {
+			return nil, fmt.Errorf("invalid --bullets %q, want at most three characters", o.bullets)
+		}
+		setters := []func(rune) tgmd.Option{
+			tgmd.WithPrimaryListBullet, tgmd.WithSecondaryListBullet, tgmd.WithAdditionalListBullet,
+		}
+		for i, b := range bullets {
+			opts = append(opts, setters[i](b))
+		}
+	}
+	if o.checkboxes != "" {
+		glyphs := []rune(o.checkboxes)
+		if len(glyphs) != 2 {
+			return nil, fmt.Errorf("invalid --checkboxes %q, want two characters", o.checkboxes)
+		}
+		opts = append(opts, tgmd.WithTaskCheckBoxes(glyphs[0], glyphs[1]))
+	}
+
+	var err error
+	opts, err = appendChoice(opts, "numbers", o.numbers, numberFormats, tgmd.WithNumberFormat)
+	if err == nil {
+		opts, err = appendChoice(opts, "table-style", o.table, tableStyles, tgmd.WithTableStyle)
+	}
+	if err == nil {
+		opts, err = appendChoice(opts, "underline", o.underline, underlineSyntaxes, tgmd.WithUnderlineSyntax)
+	}
+	if err == nil {
+		opts, err = appendChoice(opts, "images", o.images, imageModes, tgmd.WithImageMode)
+	}
+	if err == nil {
+		opts, err = appendChoice(opts, "html", o.html, htmlPolicies, tgmd.WithHTMLPolicy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.divider != "" {
+		opts = append(opts, tgmd.WithDivider(o.divider))
+	}
+	if o.strict {
+		opts = append(opts, tgmd.WithStrict())
+	}
+	if o.quote || o.expandable {
+		opts = append(opts, tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: o.expandable}))
+	}
+	if o.hashtags != "" {
+		opts = append(opts, tgmd.WithHashtags(strings.Split(o.hashtags, ",")...))
+	}
+	if o.frontMatter {
+		opts = append(opts, tgmd.WithFrontMatter())
+	}
+	return opts, nil
+}
+
+// appendChoice appends the option built from flag name when it is set.
+func appendChoice[T any](opts []tgmd.Option, name, value string, m map[string]T,
+	option func(T) tgmd.Option,
+) ([]tgmd.Option, error) {
+	if value == "" {
+		return opts, nil
+	}
+	v, err := choice(name, value, m)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, option(v)), nil
+}
+
+func headingOption(level int, e tgmd.Element) tgmd.Option {
+	return []func(tgmd.Element) tgmd.Option{
+		tgmd.WithHeading1, tgmd.WithHeading2, tgmd.WithHeading3,
+		tgmd.WithHeading4, tgmd.WithHeading5, tgmd.WithHeading6,
+	}[level-1](e)
+}
+
+// readInput reads the file name, or stdin for "-".
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// lint writes the problems found in a MarkdownV2 message and reports
+// whether it is valid.
+func lint(w io.Writer, name string, input []byte) bool {
+	diagnostics := tgmd.Lint(input)
+	for _, d := range diagnostics {
+		fmt.Fprintf(w, "%s:%s\n", name, d)
+	}
+	return len(diagnostics) == 0
+}
+
+// entitiesOutput is the JSON written for the entities format.
+type entitiesOutput struct {
+	Text     string        `json:"text"`
+	Entities []tgmd.Entity `json:"entities"`
+}
+
+// chunkOutput is the JSON line written for every message of --split.
+type chunkOutput struct {
+	Text string `json:"text"`
+}
+
+// convert writes input converted as requested by o.
+func convert(w io.Writer, input []byte, o options, opts []tgmd.Option) error {
+	switch o.format {
+	case formatMarkdownV2:
+		if o.split > 0 {
+			chunks, err := tgmd.Split(input, o.split, opts...)
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(false)
+			for _, c := range chunks {
+				if err := enc.Encode(chunkOutput{Text: string(c)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		output, err := tgmd.Convert(input, opts...)
+		if err != nil {
+			return err
+		}
+		return writeLine(w, output)
+	case formatHTML:
+		output, err := tgmd.ConvertHTML(input, opts...)
+		if err != nil {
+			return err
+		}
+		return writeLine(w, output)
+	case formatEntities:
+		text, entities, err := tgmd.ConvertEntities(input, opts...)
+		if err != nil {
+			return err
+		}
+		if entities == nil {
+			entities = []tgmd.Entity{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(entitiesOutput{Text: text, Entities: entities})
+	case formatTelegraph:
+		page, err := tgmd.ConvertTelegraph(input, opts...)
+		if err != nil {
+			return err
+		}
+		if !page.Fits() {
+			return fmt.Errorf("telegraph content is %d bytes, over the limit of %d; the first %d of %d blocks fit",
+				page.Size, tgmd.TelegraphContentLimit, page.Fitting, len(page.Content))
+		}
+		if page.Content == nil {
+			page.Content = []tgmd.TelegraphNode{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(page.Content)
+	}
+	return nil
+}
+
+// writeLine writes b followed by a newline unless it already ends with one.
+func writeLine(w io.Writer, b []byte) error {
+	if len(b) == 0 || b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	_, err := w.Write(b)
+	return err
+}
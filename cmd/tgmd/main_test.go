@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		input    string
+		code     int
+		expected string
+		stderr   string
+	}{
+		{
+			name:     "MarkdownV2",
+			input:    "# Title\n\n**a.b**",
+			expected: "*Title*\n\n*a\\.b*\n",
+		},
+		{
+			name:     "Heading And Bullet Flags",
+			args:     []string{"--h1-style", "italic", "--h1-prefix", "» ", "--bullets", "-"},
+			input:    "# Title\n\n- item",
+			expected: "_» Title_\n\n  \\- item\n",
+		},
+		{
+			name:     "Expandable Quote",
+			args:     []string{"--expandable"},
+			input:    "Line",
+			expected: "**>Line||\n",
+		},
+		{
+			name:     "Front Matter",
+			args:     []string{"--front-matter", "--hashtags", "news"},
+			input:    "---\nh1:\n  style: italic\nhashtags: [release]\n---\n# Title",
+			expected: "_Title_\n\n\\#release\n",
+		},
+		{
+			name:     "Hashtags",
+			args:     []string{"--hashtags", "news, big update"},
+			input:    "Text",
+			expected: "Text\n\n\\#news \\#big\\_update\n",
+		},
+		{
+			name:     "HTML",
+			args:     []string{"--format", "html"},
+			input:    "**a < b**",
+			expected: "<b>a &lt; b</b>\n",
+		},
+		{
+			name:     "Entities",
+			args:     []string{"--format", "entities"},
+			input:    "**bold** <b>",
+			expected: `{"text":"bold ","entities":[{"type":"bold","offset":0,"length":4}]}` + "\n",
+		},
+		{
+			name:     "Telegraph",
+			args:     []string{"--format", "telegraph"},
+			input:    "# Title\n\n**a < b**",
+			expected: `[{"tag":"h3","children":["Title"]},{"tag":"p","children":[{"tag":"strong","children":["a < b"]}]}]` + "\n",
+		},
+		{
+			name:   "Telegraph Page Too Large",
+			args:   []string{"--format", "telegraph"},
+			input:  strings.Repeat("word ", 20000),
+			code:   1,
+			stderr: "tgmd: <stdin>: telegraph content is 100028 bytes, over the limit of 65536; the first 0 of 1 blocks fit\n",
+		},
+		{
+			name:     "Split",
+			args:     []string{"--split", "8"},
+			input:    "**aaa bbb ccc**",
+			expected: `{"text":"*aaa bbb*"}` + "\n" + `{"text":"*ccc*"}` + "\n",
+		},
+		{
+			name:   "Validate",
+			args:   []string{"--validate"},
+			input:  "*a.*",
+			code:   exitFailure,
+			stderr: "<stdin>:1:3: character '.' is reserved and must be escaped\n",
+		},
+		{
+			name:  "Validate Passes",
+			args:  []string{"--validate"},
+			input: "*a\\.*",
+		},
+		{
+			name:   "Strict",
+			args:   []string{"--strict", "--table-style", "list"},
+			input:  "# Notes\n\n| a |\n|---|\n| 1 |\n",
+			code:   exitFailure,
+			stderr: "<stdin>:1:3: Heading of level 1 written as bold text\n<stdin>:3:3: Table written as a list\n",
+		},
+		{
+			name:   "Invalid Choice",
+			args:   []string{"--table-style", "grid"},
+			code:   exitUsage,
+			stderr: "tgmd: invalid --table-style \"grid\", want one of list, monospace\n",
+		},
+		{
+			name:   "Split Needs MarkdownV2",
+			args:   []string{"--split", "10", "--format", "html"},
+			code:   exitUsage,
+			stderr: "tgmd: --split needs the mdv2 format\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tc.args, strings.NewReader(tc.input), &stdout, &stderr)
+			if code != tc.code {
+				t.Errorf("Exit code mismatch: expected %d, got %d (stderr %q)", tc.code, code, stderr.String())
+			}
+			if stdout.String() != tc.expected {
+				t.Errorf("Output mismatch:\nExpected: %q\nGot:      %q", tc.expected, stdout.String())
+			}
+			if stderr.String() != tc.stderr {
+				t.Errorf("Stderr mismatch:\nExpected: %q\nGot:      %q", tc.stderr, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,559 @@
+package tgmd
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// MarkdownV2ToCommonMark converts a Telegram MarkdownV2 message back to
+// CommonMark that Convert turns into the same message.
+func MarkdownV2ToCommonMark(message []byte, opts ...Option) ([]byte, error) {
+	plain, entities, err := parseMarkdownV2(message)
+	if err != nil {
+		return nil, err
+	}
+	return EntitiesToCommonMark(plain, entities, opts...)
+}
+
+// EntitiesToCommonMark converts plain text and its entities back to
+// CommonMark, see ParseEntities.
+func EntitiesToCommonMark(plain string, entities []Entity, opts ...Option) ([]byte, error) {
+	doc, source := ParseEntities(plain, entities)
+	var buf bytes.Buffer
+	if err := NewCommonMarkRenderer(opts...).Render(&buf, source, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewCommonMarkRenderer returns a new renderer.Renderer that writes the
+// tree back as CommonMark, using the syntax of the tgmd extensions for
+// spoilers, strikethrough and underline. The underline syntax option is
+// honoured; other options do not apply.
+func NewCommonMarkRenderer(opts ...Option) renderer.Renderer {
+	return renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(newCommonMarkNodeRenderer(newConfig(opts...)), 1000),
+		),
+	)
+}
+
+// commonMarkRenderer implements renderer.NodeRenderer for CommonMark.
+type commonMarkRenderer struct {
+	config *config
+	// sub renders parts of the tree on their own, to prefix or indent
+	// their lines or to move spaces out of emphasis.
+	sub renderer.Renderer
+}
+
+func newCommonMarkNodeRenderer(config *config) renderer.NodeRenderer {
+	r := &commonMarkRenderer{config: config}
+	r.sub = renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(r, 1000),
+		),
+	)
+	return r
+}
+
+// RegisterFuncs add AST objects to commonMarkRenderer.
+func (r *commonMarkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = errorReporter{reg}
+	reg.Register(ast.KindDocument, r.document)
+	reg.Register(ast.KindParagraph, r.block)
+	reg.Register(ast.KindTextBlock, r.block)
+	reg.Register(ast.KindHeading, r.heading)
+	reg.Register(ast.KindThematicBreak, r.thematicBreak)
+	reg.Register(ast.KindCodeBlock, r.code)
+	reg.Register(ast.KindFencedCodeBlock, r.code)
+	reg.Register(ast.KindBlockquote, r.blockquote)
+	reg.Register(ast.KindList, r.renderList)
+	reg.Register(ast.KindHTMLBlock, r.htmlBlock)
+
+	reg.Register(ast.KindText, r.renderText)
+	reg.Register(ast.KindString, r.renderString)
+	reg.Register(ast.KindEmphasis, r.emphasis)
+	reg.Register(ast.KindCodeSpan, r.codeSpan)
+	reg.Register(ast.KindLink, r.link)
+	reg.Register(ast.KindImage, r.image)
+	reg.Register(ast.KindAutoLink, r.autoLink)
+	reg.Register(ast.KindRawHTML, r.rawHTML)
+	reg.Register(ext.KindStrikethrough, r.strikethrough)
+	reg.Register(ext.KindTaskCheckBox, r.taskCheckBox)
+	reg.Register(KindHidden, r.hidden)
+	reg.Register(KindUnderline, r.underline)
+	reg.Register(KindDoubleSpace, r.doubleSpace)
+}
+
+// renderChildren renders the children of n into a separate buffer.
+func (r *commonMarkRenderer) renderChildren(source []byte, n ast.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.sub.Render(&buf, source, c); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// commonMarkSeparation returns the number of newlines to put before block
+// n. A blank line is also needed where CommonMark would otherwise continue
+// the previous block: after lists and quotes, whose last paragraph takes
+// lazy continuation lines, and before ordered lists not starting at 1,
+// which cannot interrupt a paragraph.
+func commonMarkSeparation(n ast.Node) int {
+	prev := n.PreviousSibling()
+	switch {
+	case prev == nil:
+		return 0
+	case n.HasBlankPreviousLines():
+		return 2
+	case prev.Kind() == ast.KindParagraph && n.Kind() == ast.KindParagraph:
+		return 2
+	case (prev.Kind() == ast.KindList || prev.Kind() == ast.KindBlockquote) &&
+		n.Kind() == ast.KindParagraph:
+		return 2
+	case prev.Kind() == ast.KindParagraph && n.Kind() == ast.KindList &&
+		n.(*ast.List).IsOrdered() && n.(*ast.List).Start != 1:
+		return 2
+	}
+	return 1
+}
+
+func (r *commonMarkRenderer) writeSeparation(w util.BufWriter, n ast.Node) error {
+	return writeRowBytes(w, NewLineChar.Bytes(commonMarkSeparation(n)))
+}
+
+func (r *commonMarkRenderer) document(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering && node.HasChildren() {
+		return ast.WalkContinue, writeRowBytes(w, NewLineChar.Bytes(1))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *commonMarkRenderer) block(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, r.writeSeparation(w, node)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *commonMarkRenderer) heading(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		if err := r.writeSeparation(w, node); err != nil {
+			return ast.WalkStop, err
+		}
+		marker := bytes.Repeat([]byte{'#'}, node.(*ast.Heading).Level)
+		return ast.WalkContinue, writeRowBytes(w, append(marker, SpaceChar.Byte()))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *commonMarkRenderer) thematicBreak(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		if err := r.writeSeparation(w, node); err != nil {
+			return ast.WalkStop, err
+		}
+		// "---" would turn a paragraph written before it into a heading.
+		return ast.WalkContinue, writeRowBytes(w, []byte("***"))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *commonMarkRenderer) code(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeSeparation(w, node); err != nil {
+		return ast.WalkStop, err
+	}
+
+	var content []byte
+	lines := node.Lines()
+	for i := range lines.Len() {
+		line := lines.At(i)
+		content = append(content, line.Value(source)...)
+	}
+	if len(content) > 0 && !bytes.HasSuffix(content, NewLineChar.Bytes(1)) {
+		content = append(content, NewLineChar.Byte())
+	}
+
+	fence := bytes.Repeat([]byte{BackqouteChar.Byte()}, max(3, longestRun(content, BackqouteChar.Byte())+1))
+	out := append(slices.Clone(fence), codeLanguage(source, node)...)
+	out = append(out, NewLineChar.Byte())
+	out = append(out, content...)
+	return ast.WalkSkipChildren, writeRowBytes(w, append(out, fence...))
+}
+
+// longestRun returns the length of the longest run of c in b.
+func longestRun(b []byte, c byte) int {
+	longest, run := 0, 0
+	for _, x := range b {
+		if x == c {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+func (r *commonMarkRenderer) blockquote(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeSeparation(w, node); err != nil {
+		return ast.WalkStop, err
+	}
+	content, err := r.renderChildren(source, node)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	if isExpandableBlockquote(node) {
+		content = append([]byte(expandMarker+"\n"), content...)
+	}
+	return ast.WalkSkipChildren, writeRowBytes(w, prefixLines(content, []byte("> "), []byte("> ")))
+}
+
+// prefixLines writes first before the first line of content and rest
+// before the others. Prefixes of empty lines lose their trailing spaces.
+func prefixLines(content, first, rest []byte) []byte {
+	var out []byte
+	for i, line := range bytes.Split(content, NewLineChar.Bytes(1)) {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		} else {
+			out = append(out, NewLineChar.Byte())
+		}
+		if len(line) == 0 {
+			prefix = bytes.TrimRight(prefix, " ")
+		}
+		out = append(out, prefix...)
+		out = append(out, line...)
+	}
+	return out
+}
+
+func (r *commonMarkRenderer) renderList(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeSeparation(w, node); err != nil {
+		return ast.WalkStop, err
+	}
+	list := node.(*ast.List)
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		if item != list.FirstChild() {
+			separation := 1
+			if !list.IsTight || item.HasBlankPreviousLines() {
+				separation = 2
+			}
+			if err := writeRowBytes(w, NewLineChar.Bytes(separation)); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+
+		marker := []byte{'-'}
+		switch {
+		case list.IsOrdered():
+			marker = append(strconv.AppendInt(nil, int64(number), 10), list.Marker)
+			number++
+		case list.Marker == '*' || list.Marker == '+':
+			marker = []byte{list.Marker}
+		}
+		marker = append(marker, SpaceChar.Byte())
+
+		content, err := r.renderChildren(source, item)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeRowBytes(w, prefixLines(content, marker, SpaceChar.Bytes(len(marker)))); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *commonMarkRenderer) htmlBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeSeparation(w, node); err != nil {
+		return ast.WalkStop, err
+	}
+	n := node.(*ast.HTMLBlock)
+	var content []byte
+	for i := range n.Lines().Len() {
+		line := n.Lines().At(i)
+		content = append(content, line.Value(source)...)
+	}
+	if n.HasClosure() {
+		content = append(content, n.ClosureLine.Value(source)...)
+	}
+	return ast.WalkSkipChildren, writeRowBytes(w, bytes.TrimRight(content, "\n"))
+}
+
+func (r *commonMarkRenderer) renderText(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Text)
+	out := escapeCommonMark(textValue(source, n), startsLine(n))
+	switch {
+	case n.HardLineBreak():
+		out = append(out, "\\\n"...)
+	case n.SoftLineBreak():
+		out = append(out, NewLineChar.Byte())
+	}
+	return ast.WalkContinue, writeRowBytes(w, out)
+}
+
+func (r *commonMarkRenderer) renderString(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		n := node.(*ast.String)
+		if n.IsCode() {
+			return ast.WalkContinue, writeRowBytes(w, n.Value)
+		}
+		return ast.WalkContinue, writeRowBytes(w, escapeCommonMark(n.Value, false))
+	}
+	return ast.WalkContinue, nil
+}
+
+// startsLine reports whether text node n is written at the start of a line.
+func startsLine(n *ast.Text) bool {
+	prev, ok := n.PreviousSibling().(*ast.Text)
+	if n.PreviousSibling() == nil {
+		return n.Parent() != nil && n.Parent().Type() == ast.TypeBlock
+	}
+	return ok && (prev.SoftLineBreak() || prev.HardLineBreak())
+}
+
+// commonMarkEscaped holds the characters that are escaped wherever they
+// appear in text: the CommonMark inline markup and the delimiters of the
+// tgmd extensions.
+const commonMarkEscaped = "\\`*_[]<>|~+"
+
+// escapeCommonMark escapes b so it is read back as text. At the start of
+// a line, markers of headings, lists and setext underlines are escaped as
+// well and leading spaces, which CommonMark drops, are removed.
+func escapeCommonMark(b []byte, lineStart bool) []byte {
+	out := make([]byte, 0, len(b))
+	if lineStart {
+		b = bytes.TrimLeft(b, " \t")
+		digits := 0
+		for digits < len(b) && b[digits] >= '0' && b[digits] <= '9' {
+			digits++
+		}
+		switch {
+		case len(b) == 0:
+		case bytes.IndexByte([]byte("#-="), b[0]) >= 0:
+			out = append(out, SlashChar.Byte(), b[0])
+			b = b[1:]
+		case digits > 0 && digits < len(b) && (b[digits] == '.' || b[digits] == ')'):
+			out = append(out, b[:digits]...)
+			out = append(out, SlashChar.Byte(), b[digits])
+			b = b[digits+1:]
+		}
+	}
+	for _, c := range b {
+		if bytes.IndexByte([]byte(commonMarkEscaped), c) >= 0 {
+			out = append(out, SlashChar.Byte())
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// wrap writes the children of n between markers. Spaces at the edges are
+// moved outside, as CommonMark does not open or close emphasis next to
+// whitespace.
+func (r *commonMarkRenderer) wrap(w util.BufWriter, source []byte, n ast.Node, marker []byte) (
+	ast.WalkStatus, error,
+) {
+	content, err := r.renderChildren(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	trimmed := bytes.TrimLeft(content, " ")
+	out := slices.Clone(content[:len(content)-len(trimmed)])
+	content = trimmed
+	trimmed = bytes.TrimRight(content, " ")
+	if len(trimmed) > 0 {
+		out = append(out, marker...)
+		out = append(out, trimmed...)
+		out = append(out, marker...)
+	}
+	out = append(out, content[len(trimmed):]...)
+	return ast.WalkSkipChildren, writeRowBytes(w, out)
+}
+
+func (r *commonMarkRenderer) emphasis(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	return r.wrap(w, source, node, bytes.Repeat([]byte{AsteriskChar.Byte()}, node.(*ast.Emphasis).Level))
+}
+
+func (r *commonMarkRenderer) strikethrough(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	return r.wrap(w, source, node, []byte("~~"))
+}
+
+func (r *commonMarkRenderer) hidden(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	return r.wrap(w, source, node, HiddenTg.Bytes())
+}
+
+func (r *commonMarkRenderer) underline(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	marker := []byte("++")
+	if r.config.underlineSyntax == UnderlineUnderscore {
+		marker = []byte("__")
+	}
+	return r.wrap(w, source, node, marker)
+}
+
+func (r *commonMarkRenderer) codeSpan(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	content := codeSpanContent(source, node)
+	fence := bytes.Repeat([]byte{BackqouteChar.Byte()}, longestRun(content, BackqouteChar.Byte())+1)
+	// One space is stripped from each side when both are present.
+	pad := len(content) > 0 && (content[0] == BackqouteChar.Byte() ||
+		content[len(content)-1] == BackqouteChar.Byte() ||
+		(content[0] == SpaceChar.Byte() && content[len(content)-1] == SpaceChar.Byte() &&
+			len(bytes.TrimLeft(content, " ")) > 0))
+	if pad {
+		content = slices.Concat(SpaceChar.Bytes(1), content, SpaceChar.Bytes(1))
+	}
+	return ast.WalkSkipChildren, writeRowBytes(w, slices.Concat(fence, content, fence))
+}
+
+func (r *commonMarkRenderer) link(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, writeRowBytes(w, []byte{OpenBracketChar.Byte()})
+	}
+	return ast.WalkContinue, writeDestination(w, node.(*ast.Link).Destination)
+}
+
+func (r *commonMarkRenderer) image(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, writeRowBytes(w, []byte("!["))
+	}
+	return ast.WalkContinue, writeDestination(w, node.(*ast.Image).Destination)
+}
+
+// writeDestination closes link text and writes the link destination.
+// Destinations with spaces, parentheses or backslashes are enclosed in
+// angle brackets so they need no escapes, which goldmark would keep in the
+// URL; angle brackets are percent-encoded.
+func writeDestination(w util.BufWriter, url []byte) error {
+	out := []byte("](")
+	enclose := bytes.ContainsAny(url, " ()\\")
+	if enclose {
+		out = append(out, '<')
+	}
+	for _, c := range url {
+		switch c {
+		case '<':
+			out = append(out, "%3C"...)
+		case '>':
+			out = append(out, "%3E"...)
+		default:
+			out = append(out, c)
+		}
+	}
+	if enclose {
+		out = append(out, '>')
+	}
+	return writeRowBytes(w, append(out, CloseParenChar.Byte()))
+}
+
+func (r *commonMarkRenderer) autoLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkSkipChildren, writeRowBytes(w, slices.Concat([]byte{'<'}, node.(*ast.AutoLink).Label(source), []byte{'>'}))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *commonMarkRenderer) rawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkSkipChildren, writeRowBytes(w, rawHTMLContent(source, node.(*ast.RawHTML)))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *commonMarkRenderer) taskCheckBox(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		if node.(*ext.TaskCheckBox).IsChecked {
+			return ast.WalkContinue, writeRowBytes(w, []byte("[x] "))
+		}
+		return ast.WalkContinue, writeRowBytes(w, []byte("[ ] "))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *commonMarkRenderer) doubleSpace(w util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, writeRowBytes(w, SpaceChar.Bytes(2))
+	}
+	return ast.WalkContinue, nil
+}
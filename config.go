@@ -1,8 +1,14 @@
 package tgmd
 
-import "github.com/yuin/goldmark/util"
+import (
+	"strings"
 
-var Config = &config{
+	"github.com/yuin/goldmark/util"
+)
+
+// defaultConfig holds the package defaults. It is never modified; every
+// renderer works on its own copy.
+var defaultConfig = config{
 	headings: [6]Element{
 		{
 			Style:  BoldTg,
@@ -34,15 +40,102 @@ var Config = &config{
 		SquareSymbol.Rune(),
 		TriangleSymbol.Rune(),
 	},
+	taskCheckBoxes: [2]rune{
+		UncheckedSymbol.Rune(),
+		CheckedSymbol.Rune(),
+	},
+	divider: strings.Repeat("─", 10),
 	Quote: QuoteConfig{
 		Enable:     false,
 		Expandable: false,
 	},
 }
 
+// Config is an immutable rendering configuration that can be shared
+// between goroutines and passed to NewRenderer, TGMD or Convert with
+// WithConfig. The zero value is the package default configuration.
+type Config struct {
+	cfg *config
+}
+
+// DefaultConfig returns the package default configuration.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// NewConfig returns the default configuration with opts applied.
+func NewConfig(opts ...Option) Config {
+	return DefaultConfig().With(opts...)
+}
+
+// With returns a copy of c with opts applied. c itself is left unchanged.
+func (c Config) With(opts ...Option) Config {
+	cfg := c.resolve()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return Config{cfg: &cfg}
+}
+
+// Heading returns the style of headings of the given level (1-6).
+func (c Config) Heading(level int) Element {
+	return c.resolve().headings[level-1]
+}
+
+// ListBullets returns the primary, secondary and additional list bullets.
+func (c Config) ListBullets() [3]rune {
+	return c.resolve().listBullets
+}
+
+// Quote returns the document quoting configuration.
+func (c Config) Quote() QuoteConfig {
+	return c.resolve().Quote
+}
+
+// resolve returns a private copy of the configuration.
+func (c Config) resolve() config {
+	if c.cfg == nil {
+		return defaultConfig
+	}
+	return *c.cfg
+}
+
+// newConfig returns a private copy of the defaults with opts applied.
+func newConfig(opts ...Option) *config {
+	cfg := defaultConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &cfg
+}
+
 type config struct {
 	headings    [6]Element
 	listBullets [3]rune
+	// numberFormat defines how ordered list items are numbered.
+	numberFormat NumberFormat
+	// taskCheckBoxes holds the unchecked and checked task list glyphs.
+	taskCheckBoxes [2]rune
+	// tableStyle defines how GFM tables are rendered.
+	tableStyle TableStyle
+	// underlineSyntax defines the source syntax of underlined text.
+	underlineSyntax UnderlineSyntax
+	// imageMode defines how images are rendered.
+	imageMode ImageMode
+	// divider is the line written for thematic breaks.
+	divider string
+	// htmlPolicy defines what happens to raw HTML.
+	htmlPolicy HTMLPolicy
+	// strict makes conversions fail when the document has losses.
+	strict bool
+	// frontMatter enables settings at the start of the document.
+	frontMatter bool
+	// hashtags end every message.
+	hashtags []string
+	// buttons enables buttons declared in the document.
+	buttons bool
+	// linkPreview chooses the link preview, nil when disabled.
+	linkPreview *LinkPreviewConfig
 	// Quote holds configuration for the document quoting feature.
 	Quote QuoteConfig
 }
@@ -100,6 +193,74 @@ func (c *config) UpdateAdditionalListBullet(r rune) {
 	c.listBullets[2] = r
 }
 
+// listBullet returns the bullet for the given list nesting level.
+func (c *config) listBullet(level int) rune {
+	if level >= len(c.listBullets) {
+		level = len(c.listBullets) - 1
+	}
+	return c.listBullets[level]
+}
+
+// UpdateNumberFormat change default ordered list number format.
+func (c *config) UpdateNumberFormat(f NumberFormat) {
+	c.numberFormat = f
+}
+
+// UpdateTaskCheckBoxes change default task list check box glyphs.
+func (c *config) UpdateTaskCheckBoxes(unchecked, checked rune) {
+	c.taskCheckBoxes = [2]rune{unchecked, checked}
+}
+
+// UpdateTableStyle change default table style.
+func (c *config) UpdateTableStyle(s TableStyle) {
+	c.tableStyle = s
+}
+
+// UpdateUnderlineSyntax change default underline syntax.
+func (c *config) UpdateUnderlineSyntax(s UnderlineSyntax) {
+	c.underlineSyntax = s
+}
+
+// UpdateImageMode change default image mode.
+func (c *config) UpdateImageMode(m ImageMode) {
+	c.imageMode = m
+}
+
+// UpdateDivider change default thematic break line.
+func (c *config) UpdateDivider(divider string) {
+	c.divider = divider
+}
+
+// UpdateHTMLPolicy change default raw HTML policy.
+func (c *config) UpdateHTMLPolicy(p HTMLPolicy) {
+	c.htmlPolicy = p
+}
+
+// UpdateStrict change default strict mode.
+func (c *config) UpdateStrict(strict bool) {
+	c.strict = strict
+}
+
+// UpdateFrontMatter change default front matter support.
+func (c *config) UpdateFrontMatter(enable bool) {
+	c.frontMatter = enable
+}
+
+// UpdateHashtags change default hashtags footer.
+func (c *config) UpdateHashtags(tags []string) {
+	c.hashtags = normalizeHashtags(tags)
+}
+
+// UpdateButtons change default button support.
+func (c *config) UpdateButtons(enable bool) {
+	c.buttons = enable
+}
+
+// UpdateLinkPreview change default link preview configuration.
+func (c *config) UpdateLinkPreview(p LinkPreviewConfig) {
+	c.linkPreview = &p
+}
+
 // SetQuoteOptions sets the configuration for the document quoting feature.
 func (c *config) SetQuoteOptions(q QuoteConfig) {
 	c.Quote = q
@@ -112,17 +273,25 @@ type Element struct {
 	Postfix string
 }
 
-func (e Element) writeStart(w util.BufWriter) {
-	writeSpecialTagStart(w, e.Style, StringToBytes(e.Prefix))
+func (e Element) writeStart(w util.BufWriter) error {
+	return writeSpecialTagStart(w, e.Style, StringToBytes(e.Prefix))
 }
 
-func (e Element) writeEnd(w util.BufWriter) {
-	writeSpecialTagEnd(w, e.Style, StringToBytes(e.Postfix))
+func (e Element) writeEnd(w util.BufWriter) error {
+	return writeSpecialTagEnd(w, e.Style, StringToBytes(e.Postfix))
 }
 
 // An Option configures a Renderer.
 type Option func(*config)
 
+// WithConfig replaces the whole configuration with c. Options passed after
+// it are applied on top.
+func WithConfig(c Config) Option {
+	return func(dst *config) {
+		*dst = c.resolve()
+	}
+}
+
 // WithQuote sets the quote options.
 func WithQuote(q QuoteConfig) Option {
 	return func(c *config) {
@@ -192,3 +361,103 @@ func WithAdditionalListBullet(r rune) Option {
 		c.UpdateAdditionalListBullet(r)
 	}
 }
+
+// WithNumberFormat sets the number format of ordered lists.
+func WithNumberFormat(f NumberFormat) Option {
+	return func(c *config) {
+		c.UpdateNumberFormat(f)
+	}
+}
+
+// WithTaskCheckBoxes sets the glyphs of unchecked and checked task list items.
+func WithTaskCheckBoxes(unchecked, checked rune) Option {
+	return func(c *config) {
+		c.UpdateTaskCheckBoxes(unchecked, checked)
+	}
+}
+
+// WithTableStyle sets how GFM tables are rendered.
+func WithTableStyle(s TableStyle) Option {
+	return func(c *config) {
+		c.UpdateTableStyle(s)
+	}
+}
+
+// WithUnderlineSyntax sets the source syntax of underlined text.
+func WithUnderlineSyntax(s UnderlineSyntax) Option {
+	return func(c *config) {
+		c.UpdateUnderlineSyntax(s)
+	}
+}
+
+// WithImageMode sets how images are rendered.
+func WithImageMode(m ImageMode) Option {
+	return func(c *config) {
+		c.UpdateImageMode(m)
+	}
+}
+
+// WithDivider sets the line written for thematic breaks ("---"). An empty
+// divider drops them.
+func WithDivider(divider string) Option {
+	return func(c *config) {
+		c.UpdateDivider(divider)
+	}
+}
+
+// WithHTMLPolicy sets what happens to raw HTML.
+func WithHTMLPolicy(p HTMLPolicy) Option {
+	return func(c *config) {
+		c.UpdateHTMLPolicy(p)
+	}
+}
+
+// WithStrict makes Convert, ConvertHTML, ConvertEntities and ConvertResult
+// fail with a *StrictError listing every Loss when the document cannot be
+// shown by Telegram as written, e.g. headings, tables or nested quotes.
+func WithStrict() Option {
+	return func(c *config) {
+		c.UpdateStrict(true)
+	}
+}
+
+// WithFrontMatter strips the front matter from the start of documents.
+// Convert, ConvertHTML, ConvertEntities, ConvertResult and Split also apply
+// the quote and heading settings it overrides and its hashtags, and
+// ConvertResult returns it in Result.FrontMatter. Invalid front matter
+// makes them fail.
+func WithFrontMatter() Option {
+	return func(c *config) {
+		c.UpdateFrontMatter(true)
+	}
+}
+
+// WithHashtags ends messages with a line of hashtags. A leading '#' is
+// optional and spaces become underscores.
+func WithHashtags(tags ...string) Option {
+	return func(c *config) {
+		c.UpdateHashtags(tags)
+	}
+}
+
+// WithButtons removes the buttons declared in documents from the text: the
+// list following a "<!-- buttons -->" comment, one row per item, and links
+// written as "[[text](url)]{.button}", one row per line. ConvertResult
+// returns them in Result.Keyboard and fails with a *KeyboardError when
+// Telegram would reject them. Links to "callback:data" send data to the bot.
+func WithButtons() Option {
+	return func(c *config) {
+		c.UpdateButtons(true)
+	}
+}
+
+// WithLinkPreview makes ConvertResult choose the link preview of the
+// message, returned in Result.LinkPreview: the first link titled "preview"
+// or followed by "{preview}", as in [Download](https://example.com){preview},
+// or else the first link under the heading named by p.Heading. The markers
+// are removed from the text.
+func WithLinkPreview(p LinkPreviewConfig) Option {
+	return func(c *config) {
+		c.UpdateLinkPreview(p)
+	}
+}
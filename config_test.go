@@ -0,0 +1,90 @@
+package tgmd_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+func TestConfig_Immutable(t *testing.T) {
+	base := tgmd.NewConfig(tgmd.WithPrimaryListBullet('-'))
+	derived := base.With(tgmd.WithPrimaryListBullet('+'))
+
+	if got := base.ListBullets()[0]; got != '-' {
+		t.Errorf("base bullet changed: got %q", got)
+	}
+	if got := derived.ListBullets()[0]; got != '+' {
+		t.Errorf("derived bullet: expected '+', got %q", got)
+	}
+	if got := tgmd.DefaultConfig().ListBullets()[0]; got != tgmd.CircleSymbol.Rune() {
+		t.Errorf("default bullet changed: got %q", got)
+	}
+
+	got, err := tgmd.Convert([]byte("- Item"), tgmd.WithConfig(base))
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if string(got) != "  \\- Item" {
+		t.Errorf("Output mismatch: got %q", got)
+	}
+	if err := tgmd.Validate(got); err != nil {
+		t.Errorf("Output is not valid MarkdownV2: %v", err)
+	}
+}
+
+// TestConvert_ConcurrentOptions must be run with -race to be meaningful.
+func TestConvert_ConcurrentOptions(t *testing.T) {
+	input := []byte("# Title\n\n- Item")
+	styles := []struct {
+		heading tgmd.Element
+		bullet  rune
+		quote   tgmd.QuoteConfig
+	}{
+		{tgmd.Element{Style: tgmd.BoldTg}, '•', tgmd.QuoteConfig{}},
+		{tgmd.Element{Style: tgmd.ItalicsTg, Prefix: "!"}, '‣', tgmd.QuoteConfig{Enable: true}},
+		{tgmd.Element{Style: tgmd.StrikethroughTg}, '⁃', tgmd.QuoteConfig{Enable: true, Expandable: true}},
+	}
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		style := styles[i%len(styles)]
+		cfg := tgmd.NewConfig(
+			tgmd.WithHeading1(style.heading),
+			tgmd.WithPrimaryListBullet(style.bullet),
+			tgmd.WithQuote(style.quote),
+		)
+		expected, err := tgmd.Convert(input, tgmd.WithConfig(cfg))
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			md := tgmd.TGMD(tgmd.WithConfig(cfg))
+			for range 20 {
+				got, err := tgmd.Convert(input, tgmd.WithConfig(cfg))
+				if err != nil {
+					t.Errorf("Convert failed: %v", err)
+					return
+				}
+				if string(got) != string(expected) {
+					t.Errorf("Output mismatch:\nExpected: %q\nGot:      %q", expected, got)
+					return
+				}
+				html, err := tgmd.ConvertHTML(input, tgmd.WithConfig(cfg))
+				if err != nil || len(html) == 0 {
+					t.Errorf("ConvertHTML failed: %v", err)
+					return
+				}
+				if err := md.Convert(input, io.Discard); err != nil {
+					t.Errorf("shared goldmark instance failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
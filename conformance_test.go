@@ -0,0 +1,59 @@
+package tgmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// specExample is an example of the CommonMark specification, see
+// testdata/commonmark-spec.json.
+type specExample struct {
+	Markdown string `json:"markdown"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+func TestConvert_CommonMarkSpec(t *testing.T) {
+	data, err := os.ReadFile("testdata/commonmark-spec.json")
+	if err != nil {
+		t.Fatalf("Failed to read spec: %v", err)
+	}
+	var examples []specExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("Failed to parse spec: %v", err)
+	}
+
+	passes := map[string][]Option{
+		"strip":            {WithHTMLPolicy(HTMLStrip)},
+		"escape":           {WithHTMLPolicy(HTMLEscape)},
+		"translate":        {WithHTMLPolicy(HTMLTranslate)},
+		"quote":            {WithQuote(QuoteConfig{Enable: true})},
+		"expandable quote": {WithQuote(QuoteConfig{Enable: true, Expandable: true})},
+	}
+	for name, opts := range passes {
+		t.Run(name, func(t *testing.T) {
+			for _, ex := range examples {
+				output, err := convertNoPanic([]byte(ex.Markdown), opts...)
+				if err != nil {
+					t.Errorf("Example %d (%s): %v\nInput: %q", ex.Example, ex.Section, err, ex.Markdown)
+					continue
+				}
+				if err := Validate(output); err != nil {
+					t.Errorf("Example %d (%s): %v\nInput:  %q\nOutput: %q",
+						ex.Example, ex.Section, err, ex.Markdown, output)
+				}
+			}
+		})
+	}
+}
+
+func convertNoPanic(source []byte, opts ...Option) (output []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return Convert(source, opts...)
+}
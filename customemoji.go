@@ -0,0 +1,98 @@
+package tgmd
+
+import (
+	"bytes"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// customEmojiURL is the destination of images that are custom emoji,
+// followed by the emoji id.
+const customEmojiURL = "tg://emoji?id="
+
+// customEmojiID returns the id of the custom emoji at url, e.g.
+// "tg://emoji?id=5368324170671202286".
+func customEmojiID(url []byte) (string, bool) {
+	id, ok := bytes.CutPrefix(url, []byte(customEmojiURL))
+	if !ok || !isDigits(id) {
+		return "", false
+	}
+	return string(id), true
+}
+
+// isDigits reports whether b is a non-empty run of ASCII digits.
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// isCustomEmoji reports whether image n is a custom emoji.
+func isCustomEmoji(n *ast.Image) bool {
+	_, ok := customEmojiID(n.Destination)
+	return ok
+}
+
+// customEmojiAlt returns the emoji shown in place of custom emoji n where
+// it cannot be displayed: its alt text, or CustomEmojiSymbol.
+func customEmojiAlt(source []byte, n *ast.Image) []byte {
+	if alt := bytes.TrimSpace(plainText(source, n)); len(alt) > 0 {
+		return alt
+	}
+	return utf8.AppendRune(nil, CustomEmojiSymbol.Rune())
+}
+
+// customEmojiShortcut matches ":emoji[id]:".
+var customEmojiShortcut = regexp.MustCompile(`^:emoji\[([0-9]+)\]:`)
+
+type customEmojiParser struct{}
+
+var defaultCustomEmojiParser = &customEmojiParser{}
+
+// NewCustomEmojiParser initialize parser.InlineParser for the
+// ":emoji[id]:" shortcut of custom emoji.
+func NewCustomEmojiParser() parser.InlineParser {
+	return defaultCustomEmojiParser
+}
+
+// Trigger char for parser.
+func (s *customEmojiParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+// Parse source. The shortcut becomes an image of the custom emoji with
+// CustomEmojiSymbol as alt text.
+func (s *customEmojiParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	m := customEmojiShortcut.FindSubmatchIndex(line)
+	if m == nil {
+		return nil
+	}
+	link := ast.NewLink()
+	link.Destination = append([]byte(customEmojiURL), line[m[2]:m[3]]...)
+	image := ast.NewImage(link)
+	image.AppendChild(image, ast.NewString(utf8.AppendRune(nil, CustomEmojiSymbol.Rune())))
+	block.Advance(m[1])
+	return image
+}
+
+type customEmojis struct{}
+
+// CustomEmojis parses ":emoji[id]:" as the custom emoji with that id.
+var CustomEmojis = &customEmojis{}
+
+// Extend ...
+func (e *customEmojis) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(NewCustomEmojiParser(), 500),
+	))
+}
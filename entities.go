@@ -0,0 +1,597 @@
+package tgmd
+
+import (
+	"bytes"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// EntityType is the type of a Bot API MessageEntity.
+type EntityType string
+
+// define Bot API MessageEntity types.
+const (
+	EntityBold                 EntityType = "bold"
+	EntityItalic               EntityType = "italic"
+	EntityUnderline            EntityType = "underline"
+	EntityStrikethrough        EntityType = "strikethrough"
+	EntitySpoiler              EntityType = "spoiler"
+	EntityCode                 EntityType = "code"
+	EntityPre                  EntityType = "pre"
+	EntityTextLink             EntityType = "text_link"
+	EntityTextMention          EntityType = "text_mention"
+	EntityCustomEmoji          EntityType = "custom_emoji"
+	EntityBlockquote           EntityType = "blockquote"
+	EntityExpandableBlockquote EntityType = "expandable_blockquote"
+)
+
+// Entity is a Bot API MessageEntity. Offset and Length are measured in
+// UTF-16 code units.
+type Entity struct {
+	Type     EntityType `json:"type"`
+	Offset   int        `json:"offset"`
+	Length   int        `json:"length"`
+	URL      string     `json:"url,omitempty"`
+	Language string     `json:"language,omitempty"`
+	// User is the user mentioned by a text_mention entity.
+	User *EntityUser `json:"user,omitempty"`
+	// CustomEmojiID is the id of the sticker shown by a custom_emoji entity.
+	CustomEmojiID string `json:"custom_emoji_id,omitempty"`
+}
+
+// EntityUser is the Bot API User of a text_mention entity. Only the id is
+// needed to send a message.
+type EntityUser struct {
+	ID int64 `json:"id"`
+}
+
+// ConvertEntities converts source to plain text and the list of entities
+// describing its formatting, ready to be sent without a parse mode. Strict
+// mode applies as in Convert.
+func ConvertEntities(source []byte, opts ...Option) (string, []Entity, error) {
+	opts, _, err := withFrontMatter(source, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return convertEntities(source, opts)
+}
+
+// convertEntities is ConvertEntities with the front matter already applied
+// to opts.
+func convertEntities(source []byte, opts []Option) (string, []Entity, error) {
+	cfg := newConfig(opts...)
+	nr := &entityRenderer{config: cfg}
+	md := goldmark.New(
+		goldmark.WithRenderer(renderer.NewRenderer(
+			renderer.WithNodeRenderers(util.Prioritized(nr, 1000)),
+		)),
+		goldmark.WithExtensions(extensions(cfg)...),
+	)
+
+	var buf bytes.Buffer
+	pc := parser.NewContext()
+	if err := md.Convert(source, &buf, parser.WithContext(pc)); err != nil {
+		return "", nil, err
+	}
+	if err := cfg.checkLosses(pc); err != nil {
+		return "", nil, err
+	}
+
+	text := buf.Bytes()
+	entities := nr.entities
+	if cfg.Quote.Enable {
+		// Line breaks before the closing fence of a trailing pre block stay
+		// in the MarkdownV2 quote, so only those after the fence are dropped.
+		end := len(bytes.TrimRight(text, "\n"))
+		text = text[:max(end, len(text)-(nr.offset-nr.preEnd))]
+		length := utf16Len(text)
+		if length > 0 {
+			quote := EntityBlockquote
+			if cfg.Quote.Expandable {
+				quote = EntityExpandableBlockquote
+			}
+			entities = append(entities, Entity{Type: quote, Length: length})
+		}
+	}
+	sortEntities(entities)
+	return string(text), entities, nil
+}
+
+// sortEntities orders entities by offset, outer entities first.
+func sortEntities(entities []Entity) {
+	slices.SortStableFunc(entities, func(a, b Entity) int {
+		if a.Offset != b.Offset {
+			return a.Offset - b.Offset
+		}
+		return b.Length - a.Length
+	})
+}
+
+// utf16Len returns the length of b in UTF-16 code units.
+func utf16Len(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+		b = b[size:]
+	}
+	return n
+}
+
+// entityType returns the entity type matching a Markdown formatting tag.
+func entityType(tag SpecialTag) EntityType {
+	switch htmlTag(tag) {
+	case "b":
+		return EntityBold
+	case "i":
+		return EntityItalic
+	case "u":
+		return EntityUnderline
+	case "s":
+		return EntityStrikethrough
+	case "tg-spoiler":
+		return EntitySpoiler
+	case "code":
+		return EntityCode
+	case "pre":
+		return EntityPre
+	}
+	return ""
+}
+
+// entityRenderer implement renderer.NodeRenderer object that writes plain
+// text and collects the entities of a single document.
+type entityRenderer struct {
+	config   *config
+	offset   int
+	starts   []int
+	entities []Entity
+	// preEnd is the offset right after the last pre block, empty or not.
+	preEnd int
+}
+
+// RegisterFuncs add AST objects to entityRenderer.
+func (r *entityRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = errorReporter{reg}
+	reg.Register(ast.KindDocument, r.document)
+	reg.Register(ast.KindParagraph, r.paragraph)
+
+	reg.Register(ast.KindText, r.renderText)
+	reg.Register(ast.KindString, r.renderString)
+	reg.Register(ast.KindEmphasis, r.emphasis)
+
+	reg.Register(ast.KindHeading, r.heading)
+	reg.Register(ast.KindList, r.renderList)
+	reg.Register(ast.KindListItem, r.listItem)
+	reg.Register(ast.KindLink, r.link)
+	reg.Register(ast.KindImage, r.image)
+
+	reg.Register(ast.KindBlockquote, r.blockquote)
+	reg.Register(ast.KindFencedCodeBlock, r.code)
+	reg.Register(ast.KindCodeBlock, r.code)
+	reg.Register(ast.KindThematicBreak, r.thematicBreak)
+	reg.Register(ast.KindHTMLBlock, r.htmlBlock)
+	reg.Register(ast.KindRawHTML, r.rawHTML)
+	reg.Register(ast.KindAutoLink, r.autoLink)
+	reg.Register(ast.KindCodeSpan, r.codeSpan)
+
+	reg.Register(ext.KindStrikethrough, r.strikethrough)
+	reg.Register(ext.KindTaskCheckBox, r.taskCheckBox)
+	reg.Register(ext.KindTable, r.table)
+	reg.Register(KindHidden, r.hidden)
+	reg.Register(KindUnderline, r.underline)
+	reg.Register(KindDoubleSpace, r.doubleSpace)
+}
+
+func (r *entityRenderer) write(w util.BufWriter, data []byte) error {
+	r.offset += utf16Len(data)
+	return writeRowBytes(w, data)
+}
+
+func (r *entityRenderer) writeNewLines(w util.BufWriter, count int) error {
+	return r.write(w, NewLineChar.Bytes(count))
+}
+
+// open marks the start of an entity at the current offset.
+func (r *entityRenderer) open() {
+	r.starts = append(r.starts, r.offset)
+}
+
+// close ends the entity opened last, dropping it if it is empty.
+func (r *entityRenderer) close(e Entity) {
+	start := r.starts[len(r.starts)-1]
+	r.starts = r.starts[:len(r.starts)-1]
+	if e.Type == "" || r.offset == start {
+		return
+	}
+	e.Offset = start
+	e.Length = r.offset - start
+	r.entities = append(r.entities, e)
+}
+
+// span opens or closes an entity of type t depending on entering.
+func (r *entityRenderer) span(t EntityType, entering bool) {
+	if entering {
+		r.open()
+	} else {
+		r.close(Entity{Type: t})
+	}
+}
+
+func (r *entityRenderer) heading(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Heading)
+	e := r.config.headings[n.Level-1]
+	if entering {
+		if err := r.writeNewLines(w, blockSeparation(n)); err != nil {
+			return ast.WalkStop, err
+		}
+		r.open()
+		return ast.WalkContinue, r.write(w, StringToBytes(e.Prefix))
+	}
+	if err := r.write(w, StringToBytes(e.Postfix)); err != nil {
+		return ast.WalkStop, err
+	}
+	r.close(Entity{Type: entityType(e.Style)})
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) paragraph(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, r.writeNewLines(w, paragraphSeparation(node.(*ast.Paragraph)))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) renderList(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, r.writeNewLines(w, blockSeparation(node))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) listItem(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.ListItem)
+	if entering {
+		if err := r.writeNewLines(w, listItemSeparation(n)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, r.write(w, r.config.listItemPrefix(n))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) code(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeNewLines(w, blockSeparation(node)); err != nil {
+		return ast.WalkStop, err
+	}
+	r.open()
+	if err := r.write(w, bytes.TrimSuffix(codeBlockContent(source, node), []byte{NewLineChar.Byte()})); err != nil {
+		return ast.WalkStop, err
+	}
+	r.close(Entity{Type: EntityPre, Language: string(codeLanguage(source, node))})
+	r.preEnd = r.offset
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *entityRenderer) renderText(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Text)
+	if err := r.write(w, textValue(source, n)); err != nil {
+		return ast.WalkStop, err
+	}
+	if n.SoftLineBreak() || n.HardLineBreak() {
+		return ast.WalkContinue, r.writeNewLines(w, 1)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) renderString(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, r.write(w, node.(*ast.String).Value)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) emphasis(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Emphasis)
+	if n.Level == 2 {
+		r.span(EntityBold, entering)
+	} else {
+		r.span(EntityItalic, entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) link(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Link)
+	if isInsideLink(n) {
+		return ast.WalkContinue, nil
+	}
+	if entering {
+		r.open()
+	} else {
+		r.close(linkEntity(n.Destination))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) image(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Image)
+	if id, ok := customEmojiID(n.Destination); ok {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		r.open()
+		if err := r.write(w, customEmojiAlt(source, n)); err != nil {
+			return ast.WalkStop, err
+		}
+		r.close(Entity{Type: EntityCustomEmoji, CustomEmojiID: id})
+		return ast.WalkSkipChildren, nil
+	}
+	if r.config.imageMode == ImageCollect {
+		return ast.WalkSkipChildren, nil
+	}
+	link := !isInsideLink(n)
+	if entering {
+		if link {
+			r.open()
+		}
+		if !r.config.imageAltText(source, n) {
+			return ast.WalkSkipChildren, r.write(w, utf8.AppendRune(nil, ImageSymbol.Rune()))
+		}
+	} else if link {
+		r.close(Entity{Type: EntityTextLink, URL: string(n.Destination)})
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) autoLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.AutoLink)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if n.AutoLinkType == ast.AutoLinkEmail {
+		return ast.WalkContinue, r.write(w, n.Label(source))
+	}
+	r.open()
+	if err := r.write(w, n.Label(source)); err != nil {
+		return ast.WalkStop, err
+	}
+	r.close(linkEntity(n.URL(source)))
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) thematicBreak(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering && r.config.divider != "" {
+		if err := r.writeNewLines(w, blockSeparation(node)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, r.write(w, StringToBytes(r.config.divider))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) htmlBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	if text := r.config.htmlBlockText(source, node.(*ast.HTMLBlock)); len(text) > 0 {
+		if err := r.writeNewLines(w, blockSeparation(node)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkSkipChildren, r.write(w, text)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *entityRenderer) rawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.RawHTML)
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	switch r.config.htmlPolicy {
+	case HTMLEscape:
+		return ast.WalkSkipChildren, r.write(w, rawHTMLContent(source, n))
+	case HTMLTranslate:
+		if isHTMLLineBreak(source, n) {
+			return ast.WalkSkipChildren, r.writeNewLines(w, 1)
+		}
+		if tag, closing, ok := translatedHTMLTag(source, n); ok && closing {
+			r.close(Entity{Type: entityType(tag)})
+		} else if ok {
+			r.open()
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *entityRenderer) blockquote(w util.BufWriter, _ []byte, n ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		if err := r.writeNewLines(w, blockSeparation(n)); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	switch {
+	case r.config.flattensBlockquote(n):
+	case isExpandableBlockquote(n):
+		r.span(EntityExpandableBlockquote, entering)
+	default:
+		r.span(EntityBlockquote, entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) codeSpan(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		r.open()
+		if err := r.write(w, codeSpanContent(source, node)); err != nil {
+			return ast.WalkStop, err
+		}
+		r.close(Entity{Type: EntityCode})
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *entityRenderer) strikethrough(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	r.span(EntityStrikethrough, entering)
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) underline(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	r.span(EntityUnderline, entering)
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) taskCheckBox(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ext.TaskCheckBox)
+	if entering && !replacesBullet(n) {
+		glyph := utf8.AppendRune([]byte(nil), r.config.taskGlyph(n))
+		return ast.WalkContinue, r.write(w, append(glyph, SpaceChar.Byte()))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) table(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ext.Table)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeNewLines(w, blockSeparation(n)); err != nil {
+		return ast.WalkStop, err
+	}
+	if r.config.tableStyle == TableList {
+		return ast.WalkSkipChildren, r.writeTableList(w, source, n)
+	}
+	r.open()
+	if err := r.write(w, StringToBytes(tableMonospace(source, n))); err != nil {
+		return ast.WalkStop, err
+	}
+	r.close(Entity{Type: EntityPre})
+	return ast.WalkSkipChildren, nil
+}
+
+// writeTableList writes every row of table n as a list of fields.
+func (r *entityRenderer) writeTableList(w util.BufWriter, source []byte, n *ext.Table) error {
+	for i, record := range tableRecords(source, n) {
+		if i > 0 {
+			if err := r.writeNewLines(w, 1); err != nil {
+				return err
+			}
+		}
+		for j, field := range record {
+			if j > 0 {
+				if err := r.writeNewLines(w, 1); err != nil {
+					return err
+				}
+			}
+			if err := r.write(w, StringToBytes(r.config.tableRecordPrefix(j))); err != nil {
+				return err
+			}
+			if field.key != "" {
+				r.open()
+				if err := r.write(w, StringToBytes(field.key)); err != nil {
+					return err
+				}
+				r.close(Entity{Type: EntityBold})
+				if err := r.write(w, []byte(": ")); err != nil {
+					return err
+				}
+			}
+			if err := r.write(w, StringToBytes(field.value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *entityRenderer) hidden(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	r.span(EntitySpoiler, entering)
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) doubleSpace(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, nil
+}
+
+func (r *entityRenderer) document(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, nil
+	}
+	if footer := r.config.hashtagLine(); footer != nil {
+		if node.HasChildren() {
+			if err := r.writeNewLines(w, 2); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		if err := r.write(w, footer); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	if hasManyBlocks(node, r.config) {
+		return ast.WalkContinue, r.writeNewLines(w, 1)
+	}
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,145 @@
+package tgmd_test
+
+import (
+	"reflect"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+func TestConvertEntities(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		opts     []tgmd.Option
+		text     string
+		entities []tgmd.Entity
+	}{
+		{
+			name:  "UTF-16 Offsets after Emoji",
+			input: "# Heading1 🎉\n\n**b** 🎉 *i*",
+			text:  "Heading1 🎉\n\nb 🎉 i\n",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityBold, Offset: 0, Length: 11},
+				{Type: tgmd.EntityBold, Offset: 13, Length: 1},
+				{Type: tgmd.EntityItalic, Offset: 18, Length: 1},
+			},
+		},
+		{
+			name:  "Nested Entities without Escaping",
+			input: "**a ~~b.c~~** ||d_e|| [link](https://example.com/(x))",
+			text:  "a b.c d_e link",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityBold, Offset: 0, Length: 5},
+				{Type: tgmd.EntityStrikethrough, Offset: 2, Length: 3},
+				{Type: tgmd.EntitySpoiler, Offset: 6, Length: 3},
+				{Type: tgmd.EntityTextLink, Offset: 10, Length: 4, URL: "https://example.com/(x)"},
+			},
+		},
+		{
+			name:  "Underline",
+			input: "___both___",
+			opts:  []tgmd.Option{tgmd.WithUnderlineSyntax(tgmd.UnderlineUnderscore)},
+			text:  "both",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityUnderline, Offset: 0, Length: 4},
+				{Type: tgmd.EntityItalic, Offset: 0, Length: 4},
+			},
+		},
+		{
+			name:  "Code",
+			input: "`x` and\n\n```go\nfmt.Println(\"🎉\")\n```",
+			text:  "x and\n\nfmt.Println(\"🎉\")\n",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityCode, Offset: 0, Length: 1},
+				{Type: tgmd.EntityPre, Offset: 7, Length: 17, Language: "go"},
+			},
+		},
+		{
+			name:  "Custom Emoji and Mentions",
+			input: "🎉![👍](tg://emoji?id=5368324170671202286) :emoji[42]: @[Jane](user:123) [John](tg://user?id=456)",
+			text:  "🎉👍 ⭐ Jane John",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityCustomEmoji, Offset: 2, Length: 2, CustomEmojiID: "5368324170671202286"},
+				{Type: tgmd.EntityCustomEmoji, Offset: 5, Length: 1, CustomEmojiID: "42"},
+				{Type: tgmd.EntityTextMention, Offset: 7, Length: 4, User: &tgmd.EntityUser{ID: 123}},
+				{Type: tgmd.EntityTextMention, Offset: 12, Length: 4, User: &tgmd.EntityUser{ID: 456}},
+			},
+		},
+		{
+			name:  "Blockquote",
+			input: "> quote",
+			text:  "quote",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityBlockquote, Offset: 0, Length: 5},
+			},
+		},
+		{
+			name:  "Blockquote Starting with a List",
+			input: "> - a\n> - b",
+			text:  "  • a\n  • b",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityBlockquote, Offset: 0, Length: 11},
+			},
+		},
+		{
+			name:  "Blockquote with Heading and Text",
+			input: "> # h\n> text",
+			text:  "h\ntext",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityBlockquote, Offset: 0, Length: 6},
+				{Type: tgmd.EntityBold, Offset: 0, Length: 1},
+			},
+		},
+		{
+			name:  "Document Quote Ending with Empty Code",
+			input: "foo\n\n```\n```",
+			opts:  []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true})},
+			text:  "foo\n\n",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityBlockquote, Offset: 0, Length: 5},
+			},
+		},
+		{
+			name:  "Expandable Blockquote",
+			input: "Intro\n\n> [!expand]\n> one\n>\n> two",
+			text:  "Intro\n\none\n\ntwo\n",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityExpandableBlockquote, Offset: 7, Length: 8},
+			},
+		},
+		{
+			name:  "Expandable Blockquote Ending with Code",
+			input: "> [!expand]\n> Log:\n> ```\n> a_b\n> ```",
+			text:  "Log:\na_b",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityExpandableBlockquote, Offset: 0, Length: 8},
+				{Type: tgmd.EntityPre, Offset: 5, Length: 3},
+			},
+		},
+		{
+			name:  "Document as Expandable Quote",
+			input: "Line 1\n\nLine 2",
+			opts:  []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: true})},
+			text:  "Line 1\n\nLine 2",
+			entities: []tgmd.Entity{
+				{Type: tgmd.EntityExpandableBlockquote, Offset: 0, Length: 14},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, entities, err := tgmd.ConvertEntities([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("ConvertEntities failed: %v", err)
+			}
+			if text != tc.text {
+				t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", tc.text, text)
+			}
+			if !reflect.DeepEqual(entities, tc.entities) {
+				t.Errorf("Entities mismatch:\nExpected: %+v\nGot:      %+v", tc.entities, entities)
+			}
+		})
+	}
+}
@@ -68,6 +68,9 @@ func main() {
 			tgmd.Strikethroughs,
 			tgmd.Hidden,
 			tgmd.DoubleSpace,
+			tgmd.TaskList,
+			tgmd.Tables,
+			tgmd.Underline,
 		),
 	)
 	var buf bytes.Buffer
@@ -0,0 +1,71 @@
+package tgmd
+
+import (
+	"regexp"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// expandMarker is the first line of a blockquote written as an expandable
+// quote.
+const expandMarker = "[!expand]"
+
+// expandMarkerPattern matches expandMarker at the start of a line with the
+// blanks after it, and the line end when nothing follows.
+var expandMarkerPattern = regexp.MustCompile(`(?i)^[ \t]*\[!expand\](?:[ \t]*\r?\n|[ \t]+|$)`)
+
+// expandableAttribute marks the blockquotes that are expandable quotes.
+var expandableAttribute = []byte("expandable")
+
+// isExpandableBlockquote reports whether blockquote n starts with
+// expandMarker.
+func isExpandableBlockquote(n ast.Node) bool {
+	_, ok := n.Attribute(expandableAttribute)
+	return ok
+}
+
+type expandableQuoteParagraph struct{}
+
+// Transform removes expandMarker from the first paragraph of a blockquote
+// and marks the blockquote as expandable. The paragraph is removed when
+// nothing else is left; a blockquote of the marker alone stays as it is.
+func (t *expandableQuoteParagraph) Transform(node *ast.Paragraph, reader text.Reader, _ parser.Context) {
+	quote := node.Parent()
+	lines := node.Lines()
+	if quote == nil || quote.Kind() != ast.KindBlockquote || quote.FirstChild() != node || lines.Len() == 0 {
+		return
+	}
+	first := lines.At(0)
+	m := expandMarkerPattern.FindIndex(first.Value(reader.Source()))
+	if m == nil {
+		return
+	}
+	switch rest := text.NewSegment(first.Start+m[1], first.Stop); {
+	case rest.Len() > 0:
+		lines.Set(0, rest)
+	case lines.Len() > 1:
+		lines.SetSliced(1, lines.Len())
+	case node.NextSibling() != nil:
+		quote.RemoveChild(quote, node)
+	default:
+		return
+	}
+	quote.SetAttribute(expandableAttribute, true)
+}
+
+type expandableQuotes struct{}
+
+// ExpandableQuotes writes blockquotes whose first line is "[!expand]" as
+// expandable quotes.
+var ExpandableQuotes = &expandableQuotes{}
+
+// Extend ...
+func (e *expandableQuotes) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithParagraphTransformers(
+		util.Prioritized(&expandableQuoteParagraph{}, 100),
+	))
+}
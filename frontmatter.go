@@ -0,0 +1,517 @@
+package tgmd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// FrontMatter holds the settings given at the start of a document between
+// "---" lines (YAML) or "+++" lines (TOML), see WithFrontMatter.
+//
+// Only the subset of YAML and TOML needed for settings is understood: one
+// key and value per line, tables of one level ("quote:" followed by
+// indented keys, or "[quote]"), strings, booleans, integers and lists of
+// strings ("[a, b]", or "- a" lines in YAML). Unknown keys are ignored.
+type FrontMatter struct {
+	// DisableLinkPreview, DisableNotification, ProtectContent and
+	// MessageThreadID are the Bot API sendMessage settings of the same
+	// names: "disable_link_preview", "disable_notification",
+	// "protect_content" and "message_thread_id". DisableLinkPreview also
+	// overrides WithLinkPreview.
+	DisableLinkPreview  bool  `json:"disable_link_preview,omitempty"`
+	DisableNotification bool  `json:"disable_notification,omitempty"`
+	ProtectContent      bool  `json:"protect_content,omitempty"`
+	MessageThreadID     int64 `json:"message_thread_id,omitempty"`
+	// Hashtags ("hashtags") end the message, see WithHashtags.
+	Hashtags []string `json:"hashtags,omitempty"`
+	// Quote is the document quoting configuration in effect when the front
+	// matter overrides it with the "quote" table ("enable", "expandable"),
+	// nil otherwise.
+	Quote *QuoteConfig `json:"quote,omitempty"`
+	// Headings holds the heading styles in effect for the levels the front
+	// matter overrides with the "h1" to "h6" tables ("style", "prefix",
+	// "postfix"), nil for the others. Styles are named bold, italic,
+	// underline, strikethrough, spoiler or none.
+	Headings [6]*Element `json:"-"`
+}
+
+// frontMatterStyles maps the heading style names of the front matter to
+// formatting tags.
+var frontMatterStyles = map[string]SpecialTag{
+	"bold":          BoldTg,
+	"italic":        ItalicsTg,
+	"underline":     UnderlineTg,
+	"strikethrough": StrikethroughTg,
+	"spoiler":       HiddenTg,
+	"none":          nil,
+}
+
+// frontMatterBlock returns the lines between the delimiters of the front
+// matter starting source and the offset after its closing delimiter.
+func frontMatterBlock(source []byte) (content []byte, end int, ok bool) {
+	first, _, found := bytes.Cut(source, []byte{NewLineChar.Byte()})
+	delimiter := bytes.TrimRight(first, " \t\r")
+	if !found || (string(delimiter) != "---" && string(delimiter) != "+++") {
+		return nil, 0, false
+	}
+	start := len(first) + 1
+	for pos := start; pos < len(source); {
+		line, _, _ := bytes.Cut(source[pos:], []byte{NewLineChar.Byte()})
+		next := pos + len(line) + 1
+		if bytes.Equal(bytes.TrimRight(line, " \t\r"), delimiter) {
+			return source[start:pos], min(next, len(source)), true
+		}
+		pos = next
+	}
+	return nil, 0, false
+}
+
+// frontMatterEntry is a value of the front matter, keyed by its table and
+// name such as "quote.expandable".
+type frontMatterEntry struct {
+	key   string
+	value any
+	line  int
+}
+
+// parseFrontMatterEntries parses the content of a YAML or TOML front matter
+// block whose first line is line number first of the document.
+func parseFrontMatterEntries(content []byte, first int, toml bool) ([]frontMatterEntry, error) {
+	var (
+		entries []frontMatterEntry
+		table   string
+	)
+	for i, raw := range strings.Split(string(content), "\n") {
+		line := first + i
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indented := raw != strings.TrimLeft(raw, " \t")
+
+		var key, value string
+		switch {
+		case toml && strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			table = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			continue
+		case !toml && strings.HasPrefix(trimmed, "- ") && table != "":
+			// list item of the key opened last
+			item, err := parseFrontMatterValue(strings.TrimSpace(trimmed[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("tgmd: front matter line %d: %w", line, err)
+			}
+			if len(entries) == 0 || entries[len(entries)-1].key != table {
+				entries = append(entries, frontMatterEntry{key: table, value: []string{}, line: line})
+			}
+			last := &entries[len(entries)-1]
+			list, _ := last.value.([]string)
+			last.value = append(list, fmt.Sprint(item))
+			continue
+		case toml && strings.Contains(trimmed, "="):
+			key, value, _ = strings.Cut(trimmed, "=")
+		case !toml && (strings.Contains(trimmed, ": ") || strings.HasSuffix(trimmed, ":")):
+			key, value, _ = strings.Cut(trimmed, ":")
+			if !indented {
+				table = ""
+			}
+			if strings.TrimSpace(value) == "" && !indented {
+				// table or list
+				table = strings.TrimSpace(key)
+				continue
+			}
+		case toml:
+			return nil, fmt.Errorf("tgmd: front matter line %d: expected \"key = value\"", line)
+		default:
+			return nil, fmt.Errorf("tgmd: front matter line %d: expected \"key: value\"", line)
+		}
+
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		if table != "" {
+			key = table + "." + key
+		}
+		v, err := parseFrontMatterValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("tgmd: front matter line %d: %w", line, err)
+		}
+		entries = append(entries, frontMatterEntry{key: key, value: v, line: line})
+	}
+	return entries, nil
+}
+
+// parseFrontMatterValue parses a string, boolean, integer or list of
+// strings, followed by an optional comment.
+func parseFrontMatterValue(s string) (any, error) {
+	if strings.HasPrefix(s, "[") {
+		var list []string
+		rest := strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(rest, "]") {
+			item, after, err := scanFrontMatterScalar(rest, ",]")
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, fmt.Sprint(item))
+			rest = strings.TrimSpace(after)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, fmt.Errorf("unterminated list %s", s)
+			}
+		}
+		return list, checkFrontMatterComment(rest[1:])
+	}
+	v, rest, err := scanFrontMatterScalar(s, "")
+	if err != nil {
+		return nil, err
+	}
+	return v, checkFrontMatterComment(rest)
+}
+
+// scanFrontMatterScalar parses the scalar at the start of s, which ends at
+// one of stops or a comment when it is not quoted, and returns the rest.
+func scanFrontMatterScalar(s, stops string) (any, string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid string %s", s)
+		}
+		v, err := strconv.Unquote(quoted)
+		return v, s[len(quoted):], err
+	case strings.HasPrefix(s, "'"):
+		// Single quotes are escaped by doubling them.
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), s[i+1:], nil
+		}
+		return nil, "", fmt.Errorf("invalid string %s", s)
+	}
+	end := len(s)
+	if i := strings.IndexAny(s, stops); stops != "" && i >= 0 {
+		end = i
+	}
+	if i := strings.Index(s[:end], " #"); i >= 0 {
+		end = i
+	}
+	v := strings.TrimSpace(s[:end])
+	switch v {
+	case "true":
+		return true, s[end:], nil
+	case "false":
+		return false, s[end:], nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, s[end:], nil
+	}
+	return v, s[end:], nil
+}
+
+// checkFrontMatterComment reports text left after a value that is not a
+// comment.
+func checkFrontMatterComment(rest string) error {
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after value", rest)
+	}
+	return nil
+}
+
+// frontMatter is a parsed front matter: the settings it reports and the
+// configuration it overrides.
+type frontMatter struct {
+	FrontMatter
+	// hashtags reports whether the front matter sets Hashtags.
+	hashtags                     bool
+	quoteEnable, quoteExpandable *bool
+	headings                     [6]headingOverride
+}
+
+// headingOverride holds the heading settings a front matter overrides for
+// one level.
+type headingOverride struct {
+	style           *SpecialTag
+	prefix, postfix *string
+}
+
+// parseFrontMatter returns the front matter of source, or nil when source
+// has none.
+func parseFrontMatter(source []byte) (*frontMatter, error) {
+	content, _, ok := frontMatterBlock(source)
+	if !ok {
+		return nil, nil
+	}
+	entries, err := parseFrontMatterEntries(content, 2, source[0] == PlusChar.Byte())
+	if err != nil {
+		return nil, err
+	}
+
+	fm := &frontMatter{}
+	for _, e := range entries {
+		invalid := func(want string) error {
+			return fmt.Errorf("tgmd: front matter line %d: %s must be %s", e.line, e.key, want)
+		}
+		boolean := func(dst *bool) error {
+			b, ok := e.value.(bool)
+			if !ok {
+				return invalid("true or false")
+			}
+			*dst = b
+			return nil
+		}
+		str := func(dst **string) error {
+			switch e.value.(type) {
+			case []string, bool:
+				return invalid("a string")
+			}
+			s := fmt.Sprint(e.value)
+			*dst = &s
+			return nil
+		}
+
+		table, name, _ := strings.Cut(e.key, ".")
+		var heading *headingOverride
+		if len(table) == 2 && table[0] == 'h' && table[1] >= '1' && table[1] <= '6' {
+			heading = &fm.headings[table[1]-'1']
+		}
+		switch {
+		case e.key == "disable_link_preview":
+			err = boolean(&fm.DisableLinkPreview)
+		case e.key == "disable_notification":
+			err = boolean(&fm.DisableNotification)
+		case e.key == "protect_content":
+			err = boolean(&fm.ProtectContent)
+		case e.key == "message_thread_id":
+			id, ok := e.value.(int64)
+			if !ok {
+				return nil, invalid("an integer")
+			}
+			fm.MessageThreadID = id
+		case e.key == "hashtags":
+			switch v := e.value.(type) {
+			case []string:
+				fm.Hashtags = v
+			case string:
+				fm.Hashtags = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+			default:
+				return nil, invalid("a list of strings")
+			}
+			fm.Hashtags = normalizeHashtags(fm.Hashtags)
+			fm.hashtags = true
+		case e.key == "quote.enable":
+			fm.quoteEnable = new(bool)
+			err = boolean(fm.quoteEnable)
+		case e.key == "quote.expandable":
+			fm.quoteExpandable = new(bool)
+			err = boolean(fm.quoteExpandable)
+		case heading != nil && name == "style":
+			var s *string
+			if err := str(&s); err != nil {
+				return nil, err
+			}
+			style, ok := frontMatterStyles[*s]
+			if !ok {
+				return nil, invalid("bold, italic, underline, strikethrough, spoiler or none")
+			}
+			heading.style = &style
+		case heading != nil && name == "prefix":
+			err = str(&heading.prefix)
+		case heading != nil && name == "postfix":
+			err = str(&heading.postfix)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fm, nil
+}
+
+// apply overrides the configuration of c with the settings of fm.
+func (fm *frontMatter) apply(c *config) {
+	if fm.hashtags {
+		c.UpdateHashtags(fm.Hashtags)
+	}
+	if fm.DisableLinkPreview && c.linkPreview != nil {
+		preview := *c.linkPreview
+		preview.Disable = true
+		c.linkPreview = &preview
+	}
+	if fm.quoteEnable != nil {
+		c.Quote.Enable = *fm.quoteEnable
+	}
+	if fm.quoteExpandable != nil {
+		c.Quote.Expandable = *fm.quoteExpandable
+	}
+	for i, h := range fm.headings {
+		if h.style != nil {
+			c.headings[i].Style = *h.style
+		}
+		if h.prefix != nil {
+			c.headings[i].Prefix = *h.prefix
+		}
+		if h.postfix != nil {
+			c.headings[i].Postfix = *h.postfix
+		}
+	}
+}
+
+// settings returns the FrontMatter of fm, with the quote and heading
+// settings of c in effect for those fm overrides.
+func (fm *frontMatter) settings(c *config) *FrontMatter {
+	settings := fm.FrontMatter
+	if fm.quoteEnable != nil || fm.quoteExpandable != nil {
+		quote := c.Quote
+		settings.Quote = &quote
+	}
+	for i, h := range fm.headings {
+		if h != (headingOverride{}) {
+			heading := c.headings[i]
+			settings.Headings[i] = &heading
+		}
+	}
+	return &settings
+}
+
+// withFrontMatter returns opts followed by the overrides of the front
+// matter of source, and the front matter, when WithFrontMatter is given.
+// Converting with the returned options does not read the front matter
+// again.
+func withFrontMatter(source []byte, opts []Option) ([]Option, *FrontMatter, error) {
+	if !newConfig(opts...).frontMatter {
+		return opts, nil, nil
+	}
+	fm, err := parseFrontMatter(source)
+	if fm == nil || err != nil {
+		return opts, nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], fm.apply)
+	return opts, fm.settings(newConfig(opts...)), nil
+}
+
+// ParseFrontMatter returns the front matter of source when opts include
+// WithFrontMatter, with the quote and heading settings in effect, or nil.
+func ParseFrontMatter(source []byte, opts ...Option) (*FrontMatter, error) {
+	_, fm, err := withFrontMatter(source, opts)
+	return fm, err
+}
+
+// hashtagLine returns the line of hashtags written at the end of messages,
+// or nil.
+func (c *config) hashtagLine() []byte {
+	var line []byte
+	for _, tag := range c.hashtags {
+		if len(line) > 0 {
+			line = append(line, SpaceChar.Byte())
+		}
+		line = append(line, HashChar.Byte())
+		line = append(line, tag...)
+	}
+	return line
+}
+
+// normalizeHashtags returns tags without their leading '#' and with spaces
+// replaced by underscores, which Telegram hashtags cannot contain. Empty
+// tags are dropped.
+func normalizeHashtags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.TrimLeft(strings.TrimSpace(tag), "#")), "_")
+		if tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// frontMatterKind is the kind of the block holding the front matter while
+// the document is parsed.
+var frontMatterKind = ast.NewNodeKind("FrontMatter")
+
+type frontMatterNode struct {
+	ast.BaseBlock
+	// delimiter is the line opening and closing the front matter.
+	delimiter []byte
+}
+
+// Dump implements Node.Dump.
+func (n *frontMatterNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Kind implements Node.Kind.
+func (n *frontMatterNode) Kind() ast.NodeKind {
+	return frontMatterKind
+}
+
+type frontMatterParser struct{}
+
+// Trigger chars for parser.
+func (b *frontMatterParser) Trigger() []byte {
+	return []byte{MinusChar.Byte(), PlusChar.Byte()}
+}
+
+// Open starts the front matter on the first line of the document when it
+// is closed further down.
+func (b *frontMatterParser) Open(parent ast.Node, reader text.Reader, _ parser.Context) (ast.Node, parser.State) {
+	if _, segment := reader.PeekLine(); segment.Start != 0 || parent.Kind() != ast.KindDocument {
+		return nil, parser.NoChildren
+	}
+	if _, _, ok := frontMatterBlock(reader.Source()); !ok {
+		return nil, parser.NoChildren
+	}
+	line, _ := reader.PeekLine()
+	reader.AdvanceToEOL()
+	return &frontMatterNode{delimiter: bytes.TrimRight(line, " \t\r\n")}, parser.NoChildren
+}
+
+// Continue skips lines up to the closing delimiter.
+func (b *frontMatterParser) Continue(node ast.Node, reader text.Reader, _ parser.Context) parser.State {
+	line, _ := reader.PeekLine()
+	reader.AdvanceToEOL()
+	if bytes.Equal(bytes.TrimRight(line, " \t\r\n"), node.(*frontMatterNode).delimiter) {
+		return parser.Close
+	}
+	return parser.Continue | parser.NoChildren
+}
+
+// Close removes the front matter from the document.
+func (b *frontMatterParser) Close(node ast.Node, _ text.Reader, _ parser.Context) {
+	node.Parent().RemoveChild(node.Parent(), node)
+}
+
+// CanInterruptParagraph ...
+func (b *frontMatterParser) CanInterruptParagraph() bool {
+	return false
+}
+
+// CanAcceptIndentedLine ...
+func (b *frontMatterParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type frontMatterBlocks struct{}
+
+// stripFrontMatter removes the front matter from documents when
+// WithFrontMatter is given.
+var stripFrontMatter = &frontMatterBlocks{}
+
+// Extend ...
+func (e *frontMatterBlocks) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		util.Prioritized(&frontMatterParser{}, 0),
+	))
+}
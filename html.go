@@ -0,0 +1,578 @@
+package tgmd
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// ConvertHTML converts source to Telegram HTML (parse_mode=HTML). Strict
+// mode applies as in Convert.
+func ConvertHTML(source []byte, opts ...Option) ([]byte, error) {
+	opts, _, err := withFrontMatter(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	md := TGHTML(opts...)
+	pc := parser.NewContext()
+	if err := md.Convert(source, &buf, parser.WithContext(pc)); err != nil {
+		return nil, err
+	}
+	if err := newConfig(opts...).checkLosses(pc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TGHTML returns a new Goldmark instance that renders Telegram HTML.
+func TGHTML(opts ...Option) goldmark.Markdown {
+	return goldmark.New(
+		goldmark.WithRenderer(NewHTMLRenderer(opts...)),
+		goldmark.WithExtensions(extensions(newConfig(opts...))...),
+	)
+}
+
+// NewHTMLRenderer returns a new renderer.Renderer that renders Telegram HTML.
+func NewHTMLRenderer(opts ...Option) renderer.Renderer {
+	cfg := newConfig(opts...)
+	r := renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(newHTMLNodeRenderer(cfg), 1000),
+		),
+	)
+
+	if cfg.Quote.Enable {
+		return &htmlQuoteRenderer{
+			Renderer: r,
+			cfg:      cfg,
+		}
+	}
+	return r
+}
+
+type htmlQuoteRenderer struct {
+	renderer.Renderer
+	cfg *config
+}
+
+func (r *htmlQuoteRenderer) Render(w io.Writer, source []byte, n ast.Node) error {
+	var buf bytes.Buffer
+	if err := r.Renderer.Render(&buf, source, n); err != nil {
+		return err
+	}
+
+	content := bytes.TrimRight(buf.Bytes(), "\n")
+	if len(content) == 0 {
+		return nil
+	}
+
+	var result bytes.Buffer
+	if r.cfg.Quote.Expandable {
+		result.WriteString("<blockquote expandable>")
+	} else {
+		result.WriteString("<blockquote>")
+	}
+	result.Write(content)
+	result.WriteString("</blockquote>")
+
+	_, err := w.Write(result.Bytes())
+	return err
+}
+
+// HTMLRenderer implement renderer.NodeRenderer object for Telegram HTML.
+type HTMLRenderer struct {
+	config *config
+}
+
+// newHTMLNodeRenderer initialize HTMLRenderer as renderer.NodeRenderer.
+func newHTMLNodeRenderer(config *config) renderer.NodeRenderer {
+	return &HTMLRenderer{config: config}
+}
+
+// RegisterFuncs add AST objects to HTMLRenderer.
+func (r *HTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = errorReporter{reg}
+	reg.Register(ast.KindDocument, r.document)
+	reg.Register(ast.KindParagraph, r.paragraph)
+
+	reg.Register(ast.KindText, r.renderText)
+	reg.Register(ast.KindString, r.renderString)
+	reg.Register(ast.KindEmphasis, r.emphasis)
+
+	reg.Register(ast.KindHeading, r.heading)
+	reg.Register(ast.KindList, r.renderList)
+	reg.Register(ast.KindListItem, r.listItem)
+	reg.Register(ast.KindLink, r.link)
+	reg.Register(ast.KindImage, r.image)
+
+	reg.Register(ast.KindBlockquote, r.blockquote)
+	reg.Register(ast.KindFencedCodeBlock, r.code)
+	reg.Register(ast.KindCodeBlock, r.code)
+	reg.Register(ast.KindThematicBreak, r.thematicBreak)
+	reg.Register(ast.KindHTMLBlock, r.htmlBlock)
+	reg.Register(ast.KindRawHTML, r.rawHTML)
+	reg.Register(ast.KindAutoLink, r.autoLink)
+	reg.Register(ast.KindCodeSpan, r.codeSpan)
+
+	reg.Register(ext.KindStrikethrough, r.strikethrough)
+	reg.Register(ext.KindTaskCheckBox, r.taskCheckBox)
+	reg.Register(ext.KindTable, r.table)
+	reg.Register(KindHidden, r.hidden)
+	reg.Register(KindUnderline, r.underline)
+	reg.Register(KindDoubleSpace, r.doubleSpace)
+}
+
+// htmlTag returns the Telegram HTML tag name matching a Markdown formatting tag.
+func htmlTag(tag SpecialTag) string {
+	switch {
+	case len(tag) == 0:
+		return ""
+	case bytes.Equal(tag.Bytes(), BoldTg.Bytes()):
+		return "b"
+	case bytes.Equal(tag.Bytes(), ItalicsTg.Bytes()):
+		return "i"
+	case bytes.Equal(tag.Bytes(), UnderlineTg.Bytes()):
+		return "u"
+	case bytes.Equal(tag.Bytes(), StrikethroughTg.Bytes()):
+		return "s"
+	case bytes.Equal(tag.Bytes(), HiddenTg.Bytes()):
+		return "tg-spoiler"
+	case bytes.Equal(tag.Bytes(), SpanTg.Bytes()):
+		return "code"
+	case bytes.Equal(tag.Bytes(), CodeTg.Bytes()):
+		return "pre"
+	}
+	return ""
+}
+
+func writeHTMLOpenTag(w util.BufWriter, name string) error {
+	if name == "" {
+		return nil
+	}
+	return writeRowBytes(w, []byte("<"+name+">"))
+}
+
+func writeHTMLCloseTag(w util.BufWriter, name string) error {
+	if name == "" {
+		return nil
+	}
+	return writeRowBytes(w, []byte("</"+name+">"))
+}
+
+// writeHTMLTag opens or closes the element name depending on entering.
+func writeHTMLTag(w util.BufWriter, name string, entering bool) error {
+	if entering {
+		return writeHTMLOpenTag(w, name)
+	}
+	return writeHTMLCloseTag(w, name)
+}
+
+func writeHTMLText(w util.BufWriter, data []byte) error {
+	return writeEscapedBytes(w, data, htmlEscape)
+}
+
+// writeHTMLLinkStart opens a link to url.
+func writeHTMLLinkStart(w util.BufWriter, url []byte) error {
+	if err := writeRowBytes(w, []byte(`<a href="`)); err != nil {
+		return err
+	}
+	if err := writeHTMLText(w, url); err != nil {
+		return err
+	}
+	return writeRowBytes(w, []byte(`">`))
+}
+
+func (r *HTMLRenderer) heading(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Heading)
+	e := r.config.headings[n.Level-1]
+	if entering {
+		if err := writeNewLines(w, blockSeparation(n)); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeHTMLOpenTag(w, htmlTag(e.Style)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeHTMLText(w, StringToBytes(e.Prefix))
+	}
+	if err := writeHTMLText(w, StringToBytes(e.Postfix)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, writeHTMLCloseTag(w, htmlTag(e.Style))
+}
+
+func (r *HTMLRenderer) paragraph(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, writeNewLines(w, paragraphSeparation(node.(*ast.Paragraph)))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) renderList(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, writeNewLines(w, blockSeparation(node))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) listItem(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.ListItem)
+	if entering {
+		if err := writeNewLines(w, listItemSeparation(n)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeHTMLText(w, r.config.listItemPrefix(n))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) code(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := writeNewLines(w, blockSeparation(node)); err != nil {
+		return ast.WalkStop, err
+	}
+	if err := writeRowBytes(w, []byte("<pre>")); err != nil {
+		return ast.WalkStop, err
+	}
+	lang := codeLanguage(source, node)
+	if len(lang) > 0 {
+		if err := writeRowBytes(w, []byte(`<code class="language-`)); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeHTMLText(w, lang); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeRowBytes(w, []byte(`">`)); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	if err := writeHTMLText(w, bytes.TrimSuffix(codeBlockContent(source, node), []byte{NewLineChar.Byte()})); err != nil {
+		return ast.WalkStop, err
+	}
+	if len(lang) > 0 {
+		if err := writeRowBytes(w, []byte("</code>")); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	return ast.WalkSkipChildren, writeRowBytes(w, []byte("</pre>"))
+}
+
+func (r *HTMLRenderer) renderText(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Text)
+	if err := writeHTMLText(w, textValue(source, n)); err != nil {
+		return ast.WalkStop, err
+	}
+	if n.SoftLineBreak() || n.HardLineBreak() {
+		return ast.WalkContinue, writeNewLine(w)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) renderString(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, writeHTMLText(w, node.(*ast.String).Value)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) emphasis(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Emphasis)
+	tag := "i"
+	if n.Level == 2 {
+		tag = "b"
+	}
+	return ast.WalkContinue, writeHTMLTag(w, tag, entering)
+}
+
+func (r *HTMLRenderer) link(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Link)
+	if isInsideLink(n) {
+		return ast.WalkContinue, nil
+	}
+	if entering {
+		return ast.WalkContinue, writeHTMLLinkStart(w, n.Destination)
+	}
+	return ast.WalkContinue, writeHTMLCloseTag(w, "a")
+}
+
+func (r *HTMLRenderer) image(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Image)
+	if id, ok := customEmojiID(n.Destination); ok {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if err := writeRowBytes(w, []byte(`<tg-emoji emoji-id="`+id+`">`)); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeHTMLText(w, customEmojiAlt(source, n)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkSkipChildren, writeHTMLCloseTag(w, "tg-emoji")
+	}
+	if r.config.imageMode == ImageCollect {
+		return ast.WalkSkipChildren, nil
+	}
+	link := !isInsideLink(n)
+	if entering {
+		if link {
+			if err := writeHTMLLinkStart(w, n.Destination); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		if !r.config.imageAltText(source, n) {
+			return ast.WalkSkipChildren, writeHTMLText(w, utf8.AppendRune(nil, ImageSymbol.Rune()))
+		}
+	} else if link {
+		return ast.WalkContinue, writeHTMLCloseTag(w, "a")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) autoLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.AutoLink)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if n.AutoLinkType == ast.AutoLinkEmail {
+		return ast.WalkContinue, writeHTMLText(w, n.Label(source))
+	}
+	if err := writeHTMLLinkStart(w, n.URL(source)); err != nil {
+		return ast.WalkStop, err
+	}
+	if err := writeHTMLText(w, n.Label(source)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, writeHTMLCloseTag(w, "a")
+}
+
+func (r *HTMLRenderer) thematicBreak(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering && r.config.divider != "" {
+		if err := writeNewLines(w, blockSeparation(node)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeHTMLText(w, StringToBytes(r.config.divider))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) htmlBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	if text := r.config.htmlBlockText(source, node.(*ast.HTMLBlock)); len(text) > 0 {
+		if err := writeNewLines(w, blockSeparation(node)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkSkipChildren, writeHTMLText(w, text)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *HTMLRenderer) rawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.RawHTML)
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	switch r.config.htmlPolicy {
+	case HTMLEscape:
+		return ast.WalkSkipChildren, writeHTMLText(w, rawHTMLContent(source, n))
+	case HTMLTranslate:
+		if isHTMLLineBreak(source, n) {
+			return ast.WalkSkipChildren, writeNewLine(w)
+		}
+		if tag, closing, ok := translatedHTMLTag(source, n); ok {
+			return ast.WalkSkipChildren, writeHTMLTag(w, htmlTag(tag), !closing)
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *HTMLRenderer) blockquote(w util.BufWriter, _ []byte, n ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		if err := writeNewLines(w, blockSeparation(n)); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	if r.config.flattensBlockquote(n) {
+		return ast.WalkContinue, nil
+	}
+	if entering && isExpandableBlockquote(n) {
+		return ast.WalkContinue, writeRowBytes(w, []byte("<blockquote expandable>"))
+	}
+	return ast.WalkContinue, writeHTMLTag(w, "blockquote", entering)
+}
+
+func (r *HTMLRenderer) codeSpan(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	if err := writeHTMLOpenTag(w, "code"); err != nil {
+		return ast.WalkStop, err
+	}
+	if err := writeHTMLText(w, codeSpanContent(source, node)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, writeHTMLCloseTag(w, "code")
+}
+
+func (r *HTMLRenderer) strikethrough(w util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, writeHTMLTag(w, "s", entering)
+}
+
+func (r *HTMLRenderer) underline(w util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, writeHTMLTag(w, "u", entering)
+}
+
+func (r *HTMLRenderer) taskCheckBox(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ext.TaskCheckBox)
+	if entering && !replacesBullet(n) {
+		glyph := utf8.AppendRune([]byte(nil), r.config.taskGlyph(n))
+		return ast.WalkContinue, writeHTMLText(w, append(glyph, SpaceChar.Byte()))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) table(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ext.Table)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := writeNewLines(w, blockSeparation(n)); err != nil {
+		return ast.WalkStop, err
+	}
+	if r.config.tableStyle == TableList {
+		return ast.WalkSkipChildren, r.writeTableList(w, source, n)
+	}
+	if err := writeHTMLOpenTag(w, "pre"); err != nil {
+		return ast.WalkStop, err
+	}
+	if err := writeHTMLText(w, StringToBytes(tableMonospace(source, n))); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, writeHTMLCloseTag(w, "pre")
+}
+
+// writeTableList writes every row of table n as a list of fields.
+func (r *HTMLRenderer) writeTableList(w util.BufWriter, source []byte, n *ext.Table) error {
+	for i, record := range tableRecords(source, n) {
+		if i > 0 {
+			if err := writeNewLine(w); err != nil {
+				return err
+			}
+		}
+		for j, field := range record {
+			if j > 0 {
+				if err := writeNewLine(w); err != nil {
+					return err
+				}
+			}
+			if err := writeHTMLText(w, StringToBytes(r.config.tableRecordPrefix(j))); err != nil {
+				return err
+			}
+			if field.key != "" {
+				if err := writeHTMLOpenTag(w, "b"); err != nil {
+					return err
+				}
+				if err := writeHTMLText(w, StringToBytes(field.key)); err != nil {
+					return err
+				}
+				if err := writeHTMLCloseTag(w, "b"); err != nil {
+					return err
+				}
+				if err := writeHTMLText(w, []byte(": ")); err != nil {
+					return err
+				}
+			}
+			if err := writeHTMLText(w, StringToBytes(field.value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *HTMLRenderer) hidden(w util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, writeHTMLTag(w, "tg-spoiler", entering)
+}
+
+func (r *HTMLRenderer) doubleSpace(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, nil
+}
+
+func (r *HTMLRenderer) document(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		return ast.WalkContinue, nil
+	}
+	if footer := r.config.hashtagLine(); footer != nil {
+		if node.HasChildren() {
+			if err := writeNewLines(w, 2); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		if err := writeHTMLText(w, footer); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+	if hasManyBlocks(node, r.config) {
+		return ast.WalkContinue, writeNewLine(w)
+	}
+	return ast.WalkContinue, nil
+}
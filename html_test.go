@@ -0,0 +1,118 @@
+package tgmd_test
+
+import (
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+func TestConvertHTML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		opts     []tgmd.Option
+		expected string
+	}{
+		{
+			name:     "Escaping",
+			input:    "a < b && c > d",
+			expected: "a &lt; b &amp;&amp; c &gt; d",
+		},
+		{
+			name:     "Inline Entities",
+			input:    "**bold** *italic* ~~strike~~ ||hidden|| `a<b`",
+			expected: "<b>bold</b> <i>italic</i> <s>strike</s> <tg-spoiler>hidden</tg-spoiler> <code>a&lt;b</code>",
+		},
+		{
+			name:     "Underline",
+			input:    "++under++ __bold__",
+			expected: "<u>under</u> <b>bold</b>",
+		},
+		{
+			name:     "Link",
+			input:    "[goldmark](https://example.com/?a=1&b=2)",
+			expected: `<a href="https://example.com/?a=1&amp;b=2">goldmark</a>`,
+		},
+		{
+			name:     "Image",
+			input:    "![a<b](https://example.com/?a=1&b=2)",
+			expected: `<a href="https://example.com/?a=1&amp;b=2">a&lt;b</a>`,
+		},
+		{
+			name:     "Custom Emoji and Mention",
+			input:    ":emoji[42]: ![👍](tg://emoji?id=7) @[a<b](user:123)",
+			expected: `<tg-emoji emoji-id="42">⭐</tg-emoji> <tg-emoji emoji-id="7">👍</tg-emoji> <a href="tg://user?id=123">a&lt;b</a>`,
+		},
+		{
+			name:     "Translated Raw HTML",
+			input:    "<strong>b</strong> <em>i</em> <span>s</span>",
+			opts:     []tgmd.Option{tgmd.WithHTMLPolicy(tgmd.HTMLTranslate)},
+			expected: "<b>b</b> <i>i</i> s",
+		},
+		{
+			name:     "Heading with Custom Element",
+			input:    "# Title",
+			opts:     []tgmd.Option{tgmd.WithHeading1(tgmd.Element{Style: tgmd.UnderlineTg, Prefix: "<", Postfix: ">"})},
+			expected: "<u>&lt;Title&gt;</u>",
+		},
+		{
+			name:     "Fenced Code Block",
+			input:    "```go\nif a < b {}\n```",
+			expected: "<pre><code class=\"language-go\">if a &lt; b {}</code></pre>",
+		},
+		{
+			name:     "Fenced Code Block without Language",
+			input:    "```\nplain\n```",
+			expected: "<pre>plain</pre>",
+		},
+		{
+			name:     "List with Custom Bullet",
+			input:    "- Item 1\n  - Subitem",
+			opts:     []tgmd.Option{tgmd.WithPrimaryListBullet('-')},
+			expected: "  - Item 1\n    ‣ Subitem",
+		},
+		{
+			name:     "Blockquote",
+			input:    "> BQ",
+			expected: "<blockquote>BQ</blockquote>",
+		},
+		{
+			name:     "Blockquote Starting with a List",
+			input:    "> - a\n> - b",
+			expected: "<blockquote>  • a\n  • b</blockquote>",
+		},
+		{
+			name:     "Blockquote with Heading and Text",
+			input:    "> # h\n> text",
+			expected: "<blockquote><b>h</b>\ntext</blockquote>",
+		},
+		{
+			name:     "Expandable Blockquote",
+			input:    "> [!expand]\n> Line 1\n>\n> Line 2",
+			expected: "<blockquote expandable>Line 1\n\nLine 2</blockquote>",
+		},
+		{
+			name:     "Document as Expandable Quote",
+			input:    "Line 1\nLine 2",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: true})},
+			expected: "<blockquote expandable>Line 1\nLine 2</blockquote>",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tgmd.ConvertHTML([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("ConvertHTML failed: %v", err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf(
+					"Output mismatch:\nInput:    %q\nExpected: %q\nGot:      %q",
+					tc.input,
+					tc.expected,
+					string(got),
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package tgmd
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// ImageMode defines how images are rendered.
+type ImageMode int
+
+// define image modes.
+const (
+	// ImageLink renders an image as a link whose text is the alt text.
+	ImageLink ImageMode = iota
+	// ImagePlaceholder renders an image as a link whose text is ImageSymbol.
+	ImagePlaceholder
+	// ImageCollect removes images from the text. ConvertResult returns them
+	// in Result.Images so they can be sent as media.
+	ImageCollect
+)
+
+// Image is an image removed from the text in ImageCollect mode.
+type Image struct {
+	URL   string `json:"url"`
+	Alt   string `json:"alt,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Position is where the image starts in the source.
+	Position Position `json:"position"`
+}
+
+// imagesKey holds the []Image collected while parsing.
+var imagesKey = parser.NewContextKey()
+
+type imageCollector struct{}
+
+// Transform removes images from doc and stores them in pc. Paragraphs left
+// without text are removed too.
+func (t *imageCollector) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var nodes []*ast.Image
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if img, ok := n.(*ast.Image); ok && entering {
+			// Custom emoji are part of the text.
+			if !isCustomEmoji(img) {
+				nodes = append(nodes, img)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(nodes) == 0 {
+		return
+	}
+
+	images := make([]Image, 0, len(nodes))
+	for _, n := range nodes {
+		images = append(images, Image{
+			URL:      string(n.Destination),
+			Alt:      string(plainText(source, n)),
+			Title:    string(n.Title),
+			Position: positionAt(source, imageOffset(source, n)),
+		})
+	}
+	for _, n := range nodes {
+		removeInline(source, n)
+	}
+	pc.Set(imagesKey, images)
+}
+
+// removeInline removes inline node n from the tree, and its parents when
+// they are left without text. The spaces around n are merged into one, or
+// dropped when n ends its parent.
+func removeInline(source []byte, n ast.Node) {
+	parent := n.Parent()
+	prev, next := n.PreviousSibling(), n.NextSibling()
+	parent.RemoveChild(parent, n)
+	mergeSpaces(source, prev, next)
+	for parent.Type() == ast.TypeInline || parent.Kind() == ast.KindParagraph ||
+		parent.Kind() == ast.KindTextBlock {
+		if !isBlankInline(source, parent) {
+			break
+		}
+		grandparent := parent.Parent()
+		grandparent.RemoveChild(grandparent, parent)
+		parent = grandparent
+	}
+}
+
+// mergeSpaces trims the spaces left between siblings prev and next by a
+// removed inline node: the leading ones of next when prev already ends
+// with a space or a line break, or prev starts its line, and the trailing
+// ones of prev when nothing but a line break follows it.
+func mergeSpaces(source []byte, prev, next ast.Node) {
+	before, _ := prev.(*ast.Text)
+	breaks := before != nil && (before.SoftLineBreak() || before.HardLineBreak())
+	spaced := before != nil && !breaks && bytes.HasSuffix(before.Segment.Value(source), []byte{SpaceChar.Byte()})
+	if after, ok := next.(*ast.Text); ok && (prev == nil || breaks || spaced) {
+		cutText(after, after.Segment.Len()-len(bytes.TrimLeft(after.Segment.Value(source), " ")))
+	}
+	if !spaced {
+		return
+	}
+	if after, ok := before.NextSibling().(*ast.Text); before.NextSibling() == nil || ok && after.Segment.Len() == 0 {
+		before.Segment = before.Segment.TrimRightSpace(source)
+	}
+}
+
+// imageOffset returns the offset of the "![" starting image n. Inline nodes
+// do not record where they start, so the source is searched from the end
+// of the text preceding n.
+func imageOffset(source []byte, n *ast.Image) int {
+	from := 0
+	if lines := blockOf(n).Lines(); lines.Len() > 0 {
+		from = lines.At(0).Start
+	}
+search:
+	for p := ast.Node(n); p.Type() == ast.TypeInline; p = p.Parent() {
+		for c := p.PreviousSibling(); c != nil; c = c.PreviousSibling() {
+			if stop := segmentStop(c); stop > 0 {
+				from = stop
+				break search
+			}
+		}
+	}
+	if i := bytes.Index(source[from:], []byte{ExclamationChar.Byte(), OpenBracketChar.Byte()}); i >= 0 {
+		return from + i
+	}
+	return from
+}
+
+// segmentStop returns the end of the last text segment within n, or 0.
+func segmentStop(n ast.Node) int {
+	stop := 0
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			stop = t.Segment.Stop
+		}
+		return ast.WalkContinue, nil
+	})
+	return stop
+}
+
+// blockOf returns the block containing inline node n.
+func blockOf(n ast.Node) ast.Node {
+	for n.Type() == ast.TypeInline && n.Parent() != nil {
+		n = n.Parent()
+	}
+	return n
+}
+
+// isBlankInline reports whether n has no children other than blank text.
+func isBlankInline(source []byte, n ast.Node) bool {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		t, ok := c.(*ast.Text)
+		if !ok || len(bytes.TrimSpace(t.Segment.Value(source))) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type imageCollection struct{}
+
+// collectImages removes images from the document in ImageCollect mode.
+var collectImages = &imageCollection{}
+
+// Extend ...
+func (e *imageCollection) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&imageCollector{}, 1000),
+	))
+}
+
+// imageAltText reports whether image n is written with its alt text rather
+// than ImageSymbol.
+func (c *config) imageAltText(source []byte, n *ast.Image) bool {
+	return c.imageMode == ImageLink && len(bytes.TrimSpace(plainText(source, n))) > 0
+}
+
+// isInsideLink reports whether n is part of the text of a link or of an
+// image, which is written as a link. Telegram does not nest links, so
+// links and images inside them are written as plain text.
+func isInsideLink(n ast.Node) bool {
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindLink || p.Kind() == ast.KindImage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package tgmd
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// LinkPreviewOptions is the Bot API link_preview_options of a message,
+// chosen from the document with WithLinkPreview.
+type LinkPreviewOptions struct {
+	IsDisabled       bool   `json:"is_disabled,omitempty"`
+	URL              string `json:"url,omitempty"`
+	PreferLargeMedia bool   `json:"prefer_large_media,omitempty"`
+	ShowAboveText    bool   `json:"show_above_text,omitempty"`
+}
+
+// LinkPreviewConfig holds configuration for choosing the link preview of
+// messages.
+type LinkPreviewConfig struct {
+	// Heading names the section whose first link is previewed when no link
+	// is marked, e.g. "Download". Case is ignored.
+	Heading string
+	// Disable turns the link preview off.
+	Disable bool
+	// PreferLargeMedia and ShowAboveText set the options of the same names.
+	PreferLargeMedia bool
+	ShowAboveText    bool
+}
+
+// previewTitle is the title marking the link to preview.
+const previewTitle = "preview"
+
+// previewMarker follows the link to preview.
+const previewMarker = "{preview}"
+
+// linkPreviewKey holds the URL chosen for the link preview while parsing.
+var linkPreviewKey = parser.NewContextKey()
+
+type linkPreviewChooser struct {
+	config *LinkPreviewConfig
+}
+
+// Transform stores the URL of the link to preview in pc: the first link
+// titled "preview" or followed by "{preview}", or else the first link in
+// the section under the configured heading. The markers are removed.
+func (t *linkPreviewChooser) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var (
+		marked, first []byte
+		section       = 0
+	)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			switch {
+			case section > 0 && n.Level <= section:
+				section = -1
+			case section == 0 && t.config.Heading != "" &&
+				bytes.EqualFold(bytes.TrimSpace(plainText(source, n)), []byte(t.config.Heading)):
+				section = n.Level
+			}
+		case *ast.Link:
+			if _, ok := mentionedUserID(n.Destination); ok {
+				return ast.WalkContinue, nil
+			}
+			isMarked := string(n.Title) == previewTitle
+			if after, ok := n.NextSibling().(*ast.Text); ok &&
+				bytes.HasPrefix(source[after.Segment.Start:], []byte(previewMarker)) {
+				cutText(after, len(previewMarker))
+				isMarked = true
+			}
+			if isMarked {
+				n.Title = nil
+				if marked == nil {
+					marked = n.Destination
+				}
+			}
+			if section > 0 && first == nil {
+				first = n.Destination
+			}
+		case *ast.AutoLink:
+			if section > 0 && first == nil && n.AutoLinkType == ast.AutoLinkURL {
+				first = n.URL(source)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if marked == nil {
+		marked = first
+	}
+	if marked != nil {
+		pc.Set(linkPreviewKey, string(marked))
+	}
+}
+
+// linkPreviewOptions returns the link preview of a document whose chosen
+// link is url, or nil without WithLinkPreview.
+func (c *config) linkPreviewOptions(url string) *LinkPreviewOptions {
+	if c.linkPreview == nil {
+		return nil
+	}
+	if c.linkPreview.Disable {
+		return &LinkPreviewOptions{IsDisabled: true}
+	}
+	o := &LinkPreviewOptions{
+		URL:              url,
+		PreferLargeMedia: c.linkPreview.PreferLargeMedia,
+		ShowAboveText:    c.linkPreview.ShowAboveText,
+	}
+	if *o == (LinkPreviewOptions{}) {
+		return nil
+	}
+	return o
+}
+
+type linkPreviews struct {
+	config *LinkPreviewConfig
+}
+
+// Extend ...
+func (e *linkPreviews) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		// After buttons are removed, so that they are not previewed.
+		util.Prioritized(&linkPreviewChooser{config: e.config}, 60),
+	))
+}
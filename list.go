@@ -0,0 +1,72 @@
+package tgmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NumberFormat defines how the items of ordered lists are numbered. Every
+// format writes its own delimiter: whether the source list used ')' or '.'
+// is not kept.
+type NumberFormat int
+
+// define ordered list number formats.
+const (
+	// NumberDot renders "1.", "2.", "3.".
+	NumberDot NumberFormat = iota
+	// NumberParen renders "1)", "2)", "3)".
+	NumberParen
+	// NumberRoman renders "I.", "II.", "III.".
+	NumberRoman
+	// NumberKeycap renders emoji keycap digits "1️⃣", "2️⃣", "3️⃣".
+	NumberKeycap
+)
+
+// Format returns the list marker for number n.
+func (f NumberFormat) Format(n int) string {
+	switch f {
+	case NumberParen:
+		return strconv.Itoa(n) + string(CloseParenChar)
+	case NumberRoman:
+		if n > 0 && n < 4000 {
+			return toRoman(n) + string(DotChar)
+		}
+	case NumberKeycap:
+		return toKeycap(n)
+	}
+	return strconv.Itoa(n) + string(DotChar)
+}
+
+var romanNumerals = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"},
+	{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
+	{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+func toRoman(n int) string {
+	var b strings.Builder
+	for _, r := range romanNumerals {
+		for n >= r.value {
+			b.WriteString(r.symbol)
+			n -= r.value
+		}
+	}
+	return b.String()
+}
+
+func toKeycap(n int) string {
+	if n == 10 {
+		return "🔟"
+	}
+	var b strings.Builder
+	for _, d := range strconv.Itoa(n) {
+		b.WriteRune(d)
+		if d >= '0' && d <= '9' {
+			b.WriteString("\uFE0F\u20E3")
+		}
+	}
+	return b.String()
+}
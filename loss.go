@@ -0,0 +1,186 @@
+package tgmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Loss is a part of the document that Telegram cannot show as written.
+type Loss struct {
+	// Kind is the kind of the node, e.g. "Heading" or "Table".
+	Kind string `json:"kind"`
+	// Position is where the node starts in the source.
+	Position Position `json:"position"`
+	// Action tells what is written instead.
+	Action string `json:"action"`
+}
+
+// String formats l as "line:column: kind action".
+func (l Loss) String() string {
+	return fmt.Sprintf("%d:%d: %s %s", l.Position.Line, l.Position.Column, l.Kind, l.Action)
+}
+
+// StrictError is returned in strict mode for a document that cannot be
+// converted without losses.
+type StrictError struct {
+	Losses []Loss
+}
+
+// Error implements error.
+func (e *StrictError) Error() string {
+	var b strings.Builder
+	b.WriteString("tgmd: lossy conversion: ")
+	b.WriteString(e.Losses[0].String())
+	if more := len(e.Losses) - 1; more > 0 {
+		fmt.Fprintf(&b, " (and %d more)", more)
+	}
+	return b.String()
+}
+
+// lossesKey holds the []Loss found while parsing.
+var lossesKey = parser.NewContextKey()
+
+// checkLosses returns a *StrictError for the losses found in pc when
+// strict mode is enabled.
+func (c *config) checkLosses(pc parser.Context) error {
+	if losses, _ := pc.Get(lossesKey).([]Loss); c.strict && len(losses) > 0 {
+		return &StrictError{Losses: losses}
+	}
+	return nil
+}
+
+// kindRecorder records the node kinds a NodeRenderer registers.
+type kindRecorder map[ast.NodeKind]bool
+
+// Register implements renderer.NodeRendererFuncRegisterer.
+func (r kindRecorder) Register(kind ast.NodeKind, _ renderer.NodeRendererFunc) {
+	r[kind] = true
+}
+
+// renderedKinds holds the node kinds the renderers have a function for.
+var renderedKinds = func() kindRecorder {
+	kinds := kindRecorder{}
+	(&Renderer{}).RegisterFuncs(kinds)
+	return kinds
+}()
+
+type lossReporter struct {
+	config *config
+}
+
+// Transform stores the losses of doc in pc. It runs before the nodes the
+// configuration drops are removed.
+func (t *lossReporter) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var losses []Loss
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		action := t.config.lossOf(source, n)
+		if action != "" {
+			losses = append(losses, Loss{
+				Kind:     n.Kind().String(),
+				Position: positionAt(source, nodeOffset(source, n)),
+				Action:   action,
+			})
+		}
+		if n.Kind() == extast.KindTable {
+			// Rows and cells are written by the table.
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(losses) > 0 {
+		pc.Set(lossesKey, losses)
+	}
+}
+
+// lossOf tells what is written instead of node n, or "" when n is written
+// as it is.
+func (c *config) lossOf(source []byte, n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		if t := entityType(c.headings[n.Level-1].Style); t != "" {
+			return fmt.Sprintf("of level %d written as %s text", n.Level, t)
+		}
+		return fmt.Sprintf("of level %d written as plain text", n.Level)
+	case *ast.Image:
+		switch {
+		case isCustomEmoji(n), c.imageMode == ImageCollect:
+			return ""
+		case isInsideLink(n) && c.imageAltText(source, n):
+			return "inside a link written as its alt text"
+		case isInsideLink(n):
+			return "inside a link written as " + string(ImageSymbol.Rune())
+		case c.imageAltText(source, n):
+			return "written as a link named by its alt text"
+		}
+		return "written as a link named " + string(ImageSymbol.Rune())
+	case *ast.Link:
+		if isInsideLink(n) {
+			return "inside a link written as plain text"
+		}
+	case *extast.Table:
+		if c.tableStyle == TableList {
+			return "written as a list"
+		}
+		return "written as a monospace block"
+	case *ast.Blockquote:
+		switch {
+		case c.Quote.Enable:
+			return "merged into the quote around the document"
+		case isNestedBlockquote(n):
+			return "merged into the outer quote"
+		}
+	case *ast.ThematicBreak:
+		if c.divider == "" {
+			return "dropped"
+		}
+		return "written as a divider line"
+	case *ast.HTMLBlock:
+		switch c.htmlPolicy {
+		case HTMLStrip:
+			return "dropped"
+		case HTMLEscape:
+			return "written as text"
+		}
+		return "reduced to its text"
+	case *ast.RawHTML:
+		switch {
+		case c.htmlPolicy == HTMLEscape:
+			return "written as text"
+		case c.htmlPolicy == HTMLTranslate && isHTMLLineBreak(source, n):
+			return ""
+		case c.htmlPolicy == HTMLTranslate:
+			if _, _, ok := translatedHTMLTag(source, n); ok {
+				return ""
+			}
+		}
+		return "dropped"
+	default:
+		if !renderedKinds[n.Kind()] && n.Kind() != ast.KindTextBlock {
+			return "is not supported, only its text is written"
+		}
+	}
+	return ""
+}
+
+type lossReports struct {
+	config *config
+}
+
+// Extend ...
+func (e *lossReports) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&lossReporter{config: e.config}, 100),
+	))
+}
@@ -0,0 +1,83 @@
+package tgmd
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mentionURL is the destination of links that mention a user, followed by
+// the user id.
+const mentionURL = "tg://user?id="
+
+// mentionedUserID returns the id of the user mentioned by a link to url,
+// e.g. "tg://user?id=123".
+func mentionedUserID(url []byte) (int64, bool) {
+	id, ok := bytes.CutPrefix(url, []byte(mentionURL))
+	if !ok || !isDigits(id) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	return n, err == nil
+}
+
+// linkEntity returns the entity of a link to url: a text mention for
+// "tg://user?id=" links and a text link otherwise.
+func linkEntity(url []byte) Entity {
+	if id, ok := mentionedUserID(url); ok {
+		return Entity{Type: EntityTextMention, User: &EntityUser{ID: id}}
+	}
+	return Entity{Type: EntityTextLink, URL: string(url)}
+}
+
+// mentionShortcut matches "@[name](user:id)".
+var mentionShortcut = regexp.MustCompile(`^@\[((?:[^\]\\\n]|\\.)+)\]\(user:([0-9]+)\)`)
+
+type mentionParser struct{}
+
+var defaultMentionParser = &mentionParser{}
+
+// NewMentionParser initialize parser.InlineParser for the
+// "@[name](user:id)" shortcut of user mentions.
+func NewMentionParser() parser.InlineParser {
+	return defaultMentionParser
+}
+
+// Trigger char for parser.
+func (s *mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+// Parse source. The shortcut becomes a link to "tg://user?id=" whose text
+// is the name.
+func (s *mentionParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	m := mentionShortcut.FindSubmatchIndex(line)
+	if m == nil {
+		return nil
+	}
+	link := ast.NewLink()
+	link.Destination = append([]byte(mentionURL), line[m[4]:m[5]]...)
+	link.AppendChild(link, ast.NewTextSegment(text.NewSegment(segment.Start+m[2], segment.Start+m[3])))
+	block.Advance(m[1])
+	return link
+}
+
+type mentions struct{}
+
+// Mentions parses "@[name](user:id)" as a mention of the user with that
+// id.
+var Mentions = &mentions{}
+
+// Extend ...
+func (e *mentions) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(NewMentionParser(), 500),
+	))
+}
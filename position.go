@@ -0,0 +1,64 @@
+package tgmd
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Position is a location in the Markdown source.
+type Position struct {
+	// Offset is the byte offset from the start of the source.
+	Offset int `json:"offset"`
+	// Line is the 1-based line number.
+	Line int `json:"line"`
+	// Column is the 1-based column, counted in characters.
+	Column int `json:"column"`
+}
+
+// positionAt returns the Position of the byte at offset in source.
+func positionAt(source []byte, offset int) Position {
+	offset = min(max(offset, 0), len(source))
+	before := source[:offset]
+	lineStart := bytes.LastIndexByte(before, NewLineChar.Byte()) + 1
+	return Position{
+		Offset: offset,
+		Line:   bytes.Count(before, []byte{NewLineChar.Byte()}) + 1,
+		Column: utf8.RuneCount(before[lineStart:]) + 1,
+	}
+}
+
+// nodeOffset returns where n starts in source, as near as the tree records
+// it: inline markup and container blocks start at their first text.
+func nodeOffset(source []byte, n ast.Node) int {
+	switch n := n.(type) {
+	case *ast.Image:
+		return imageOffset(source, n)
+	case *ast.RawHTML:
+		if n.Segments.Len() > 0 {
+			return n.Segments.At(0).Start
+		}
+	}
+	offset := -1
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch {
+		case !entering:
+			return ast.WalkContinue, nil
+		case c.Type() == ast.TypeBlock && c.Lines().Len() > 0:
+			offset = c.Lines().At(0).Start
+		case c.Kind() == ast.KindText:
+			offset = c.(*ast.Text).Segment.Start
+		default:
+			return ast.WalkContinue, nil
+		}
+		return ast.WalkStop, nil
+	})
+	if offset < 0 {
+		if block := blockOf(n); block.Type() == ast.TypeBlock && block.Lines().Len() > 0 {
+			return block.Lines().At(0).Start
+		}
+		return 0
+	}
+	return offset
+}
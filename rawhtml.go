@@ -0,0 +1,207 @@
+package tgmd
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// HTMLPolicy defines what happens to raw HTML in the source.
+type HTMLPolicy int
+
+// define raw HTML policies.
+const (
+	// HTMLStrip drops raw HTML. Text between inline tags is kept.
+	HTMLStrip HTMLPolicy = iota
+	// HTMLEscape writes raw HTML as text.
+	HTMLEscape
+	// HTMLTranslate turns known inline tags into Telegram formatting and
+	// "<br>" into a line break. Other tags are dropped, HTML blocks are
+	// reduced to their text.
+	HTMLTranslate
+)
+
+// knownHTMLTags maps the inline HTML tags understood by HTMLTranslate to
+// Telegram formatting.
+var knownHTMLTags = map[string]SpecialTag{
+	"b":          BoldTg,
+	"strong":     BoldTg,
+	"i":          ItalicsTg,
+	"em":         ItalicsTg,
+	"u":          UnderlineTg,
+	"ins":        UnderlineTg,
+	"s":          StrikethroughTg,
+	"strike":     StrikethroughTg,
+	"del":        StrikethroughTg,
+	"tg-spoiler": HiddenTg,
+}
+
+// rawHTMLContent returns the source of an ast.RawHTML or ast.HTMLBlock.
+func rawHTMLContent(source []byte, n ast.Node) []byte {
+	var content []byte
+	switch n := n.(type) {
+	case *ast.RawHTML:
+		for i := range n.Segments.Len() {
+			segment := n.Segments.At(i)
+			content = append(content, segment.Value(source)...)
+		}
+	case *ast.HTMLBlock:
+		content = codeBlockContent(source, n)
+		if n.HasClosure() {
+			content = append(content, n.ClosureLine.Value(source)...)
+		}
+	}
+	return content
+}
+
+// parseHTMLTag returns the lower-cased name of the single tag raw and
+// whether it is a closing tag.
+func parseHTMLTag(raw []byte) (name string, closing bool, ok bool) {
+	if len(raw) < 3 || raw[0] != '<' || raw[len(raw)-1] != '>' {
+		return "", false, false
+	}
+	raw = raw[1:]
+	if raw[0] == '/' {
+		closing = true
+		raw = raw[1:]
+	}
+	end := bytes.IndexFunc(raw, func(r rune) bool {
+		return !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	if end <= 0 {
+		return "", false, false
+	}
+	return strings.ToLower(string(raw[:end])), closing, true
+}
+
+// isHTMLLineBreak reports whether n is a "<br>" tag.
+func isHTMLLineBreak(source []byte, n *ast.RawHTML) bool {
+	name, closing, ok := parseHTMLTag(rawHTMLContent(source, n))
+	return ok && !closing && name == "br"
+}
+
+// translatedHTMLTag returns the formatting n stands for under
+// HTMLTranslate. Tags without a matching partner among the siblings of n
+// are not translated, so the output never has an unclosed entity.
+func translatedHTMLTag(source []byte, n *ast.RawHTML) (tag SpecialTag, closing bool, ok bool) {
+	name, closing, ok := parseHTMLTag(rawHTMLContent(source, n))
+	if !ok {
+		return nil, false, false
+	}
+	tag, ok = knownHTMLTags[name]
+	if !ok {
+		return nil, false, false
+	}
+
+	next := ast.Node.NextSibling
+	if closing {
+		next = ast.Node.PreviousSibling
+	}
+	depth := map[string]int{}
+	for c := next(n); c != nil; c = next(c) {
+		raw, isRaw := c.(*ast.RawHTML)
+		if !isRaw {
+			continue
+		}
+		cName, cClosing, cOK := parseHTMLTag(rawHTMLContent(source, raw))
+		if _, known := knownHTMLTags[cName]; !cOK || !known {
+			continue
+		}
+		if cClosing == closing {
+			depth[cName]++
+			continue
+		}
+		if cName == name && depth[name] == 0 {
+			// Crossed tags such as "<b><i></b></i>" are not translated.
+			for _, d := range depth {
+				if d != 0 {
+					return nil, false, false
+				}
+			}
+			return tag, closing, true
+		}
+		if depth[cName]--; depth[cName] < 0 {
+			return nil, false, false
+		}
+	}
+	return nil, false, false
+}
+
+// stripHTMLTags returns the text of an HTML block, without its tags and
+// blank lines.
+func stripHTMLTags(raw []byte) []byte {
+	var text []byte
+	for len(raw) > 0 {
+		start := bytes.IndexByte(raw, LessThanChar.Byte())
+		if start < 0 {
+			text = append(text, raw...)
+			break
+		}
+		text = append(text, raw[:start]...)
+		end := bytes.IndexByte(raw[start:], GreaterThanChar.Byte())
+		if end < 0 {
+			text = append(text, raw[start:]...)
+			break
+		}
+		if name, _, ok := parseHTMLTag(raw[start : start+end+1]); ok && name == "br" {
+			text = append(text, NewLineChar.Byte())
+		}
+		raw = raw[start+end+1:]
+	}
+
+	var lines [][]byte
+	for _, line := range bytes.Split(text, []byte{NewLineChar.Byte()}) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return bytes.Join(lines, []byte{NewLineChar.Byte()})
+}
+
+// htmlBlockText returns what is written for HTML block n under the
+// configured policy.
+func (c *config) htmlBlockText(source []byte, n *ast.HTMLBlock) []byte {
+	switch c.htmlPolicy {
+	case HTMLEscape:
+		return bytes.TrimRight(rawHTMLContent(source, n), "\n")
+	case HTMLTranslate:
+		return stripHTMLTags(rawHTMLContent(source, n))
+	}
+	return nil
+}
+
+type htmlBlockRemover struct {
+	config *config
+}
+
+// Transform removes the HTML blocks that the configured policy writes
+// nothing for, so they do not leave blank lines behind.
+func (t *htmlBlockRemover) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	source := reader.Source()
+	var blocks []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if block, ok := n.(*ast.HTMLBlock); ok && entering && len(t.config.htmlBlockText(source, block)) == 0 {
+			blocks = append(blocks, block)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, block := range blocks {
+		block.Parent().RemoveChild(block.Parent(), block)
+	}
+}
+
+type htmlBlocks struct {
+	config *config
+}
+
+// Extend ...
+func (e *htmlBlocks) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&htmlBlockRemover{config: e.config}, 1000),
+	))
+}
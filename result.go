@@ -0,0 +1,69 @@
+package tgmd
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/parser"
+)
+
+// Result is a converted message along with the parts of the document that
+// are sent apart from the text.
+type Result struct {
+	// Text is the Telegram MarkdownV2 message.
+	Text []byte
+	// Images lists the images removed from Text in ImageCollect mode, in
+	// document order.
+	Images []Image
+	// Losses lists the parts of the document Telegram cannot show as
+	// written, in document order.
+	Losses []Loss
+	// FrontMatter holds the settings at the start of the document with
+	// WithFrontMatter, or nil.
+	FrontMatter *FrontMatter
+	// Keyboard holds the buttons removed from Text with WithButtons, or nil.
+	Keyboard *InlineKeyboardMarkup
+	// LinkPreview holds the link preview chosen with WithLinkPreview, or nil
+	// when Telegram should choose it.
+	LinkPreview *LinkPreviewOptions
+}
+
+// ConvertResult converts source like Convert and also returns what was
+// collected from it. Strict mode applies as in Convert.
+func ConvertResult(source []byte, opts ...Option) (*Result, error) {
+	opts, fm, err := withFrontMatter(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	return convertResult(source, opts, fm)
+}
+
+// convertResult is ConvertResult with front matter fm already applied to
+// opts.
+func convertResult(source []byte, opts []Option, fm *FrontMatter) (*Result, error) {
+	var buf bytes.Buffer
+	pc := parser.NewContext()
+	if err := TGMD(opts...).Convert(source, &buf, parser.WithContext(pc)); err != nil {
+		return nil, err
+	}
+	cfg := newConfig(opts...)
+	if err := cfg.checkLosses(pc); err != nil {
+		return nil, err
+	}
+	images, _ := pc.Get(imagesKey).([]Image)
+	losses, _ := pc.Get(lossesKey).([]Loss)
+	keyboard, _ := pc.Get(keyboardKey).(*InlineKeyboardMarkup)
+	if keyboard != nil {
+		if err := keyboard.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	previewURL, _ := pc.Get(linkPreviewKey).(string)
+	return &Result{
+		Text:        buf.Bytes(),
+		Images:      images,
+		Losses:      losses,
+		FrontMatter: fm,
+		Keyboard:    keyboard,
+		LinkPreview: cfg.linkPreviewOptions(previewURL),
+	}, nil
+}
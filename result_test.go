@@ -0,0 +1,471 @@
+package tgmd_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+func TestConvertResult_CollectImages(t *testing.T) {
+	source := "Release notes.\n\n![Screenshot](https://example.com/a.png \"Main screen\")\n\nIcons: ![](b.png) ![dark](c.png)"
+	result, err := tgmd.ConvertResult([]byte(source), tgmd.WithImageMode(tgmd.ImageCollect))
+	if err != nil {
+		t.Fatalf("ConvertResult failed: %v", err)
+	}
+
+	expectedText := "Release notes\\.\n\nIcons:\n"
+	if string(result.Text) != expectedText {
+		t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", expectedText, result.Text)
+	}
+
+	expectedImages := []tgmd.Image{
+		{
+			URL:      "https://example.com/a.png",
+			Alt:      "Screenshot",
+			Title:    "Main screen",
+			Position: tgmd.Position{Offset: 16, Line: 3, Column: 1},
+		},
+		{
+			URL:      "b.png",
+			Position: tgmd.Position{Offset: 79, Line: 5, Column: 8},
+		},
+		{
+			URL:      "c.png",
+			Alt:      "dark",
+			Position: tgmd.Position{Offset: 90, Line: 5, Column: 19},
+		},
+	}
+	if !reflect.DeepEqual(result.Images, expectedImages) {
+		t.Errorf("Images mismatch:\nExpected: %+v\nGot:      %+v", expectedImages, result.Images)
+	}
+}
+
+func TestConvertResult_CollectImagesSpaces(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Start of line", "![x](x.png) text", "text"},
+		{"Between words", "a ![x](x.png) b", "a b"},
+		{"End of line", "a ![x](x.png)\nb", "a\nb"},
+		{"After line break", "a\n![x](x.png) b", "a\nb"},
+		{"After emphasis", "*a* ![x](x.png) b", "_a_ b"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tgmd.ConvertResult([]byte(tc.input), tgmd.WithImageMode(tgmd.ImageCollect))
+			if err != nil {
+				t.Fatalf("ConvertResult failed: %v", err)
+			}
+			if string(result.Text) != tc.expected {
+				t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", tc.expected, result.Text)
+			}
+		})
+	}
+}
+
+func TestConvertResult_CollectImagesOnly(t *testing.T) {
+	result, err := tgmd.ConvertResult([]byte("![a](a.png)\n\n![b](b.png)"),
+		tgmd.WithImageMode(tgmd.ImageCollect), tgmd.WithQuote(tgmd.QuoteConfig{Enable: true}))
+	if err != nil {
+		t.Fatalf("ConvertResult failed: %v", err)
+	}
+	if len(result.Text) != 0 || len(result.Images) != 2 {
+		t.Errorf("Expected only images, got %q %+v", result.Text, result.Images)
+	}
+}
+
+func TestConvertResult_KeepsImagesInText(t *testing.T) {
+	result, err := tgmd.ConvertResult([]byte("![cat](cat.png)"))
+	if err != nil {
+		t.Fatalf("ConvertResult failed: %v", err)
+	}
+	if string(result.Text) != "[cat](cat.png)" || result.Images != nil {
+		t.Errorf("Unexpected result: %q %+v", result.Text, result.Images)
+	}
+}
+
+func TestConvertResult_Losses(t *testing.T) {
+	source := "# Title\n\n> quote\n> > nested\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n<div>x</div>\n\nok <span>y</span> ![img](i.png)\n"
+	result, err := tgmd.ConvertResult([]byte(source))
+	if err != nil {
+		t.Fatalf("ConvertResult failed: %v", err)
+	}
+	var got []string
+	for _, loss := range result.Losses {
+		got = append(got, loss.String())
+	}
+	expected := []string{
+		"1:3: Heading of level 1 written as bold text",
+		"4:5: Blockquote merged into the outer quote",
+		"6:3: Table written as a monospace block",
+		"10:1: HTMLBlock dropped",
+		"12:4: RawHTML dropped",
+		"12:11: RawHTML dropped",
+		"12:19: Image written as a link named by its alt text",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Losses mismatch:\nExpected: %q\nGot:      %q", expected, got)
+	}
+}
+
+func TestConvert_Strict(t *testing.T) {
+	if _, err := tgmd.Convert([]byte("Plain **text** and a [link](https://example.com)."), tgmd.WithStrict()); err != nil {
+		t.Errorf("Lossless document failed: %v", err)
+	}
+
+	_, err := tgmd.Convert([]byte("# Title\n\n---\n"), tgmd.WithStrict())
+	var strictErr *tgmd.StrictError
+	if !errors.As(err, &strictErr) || len(strictErr.Losses) != 2 {
+		t.Fatalf("Expected a *StrictError with 2 losses, got %v", err)
+	}
+	expected := "tgmd: lossy conversion: 1:3: Heading of level 1 written as bold text (and 1 more)"
+	if err.Error() != expected {
+		t.Errorf("Error mismatch:\nExpected: %q\nGot:      %q", expected, err.Error())
+	}
+	if _, _, err := tgmd.ConvertEntities([]byte("---"), tgmd.WithStrict()); !errors.As(err, &strictErr) {
+		t.Errorf("Expected ConvertEntities to fail, got %v", err)
+	}
+}
+
+func TestConvertResult_FrontMatter(t *testing.T) {
+	testCases := []struct {
+		name         string
+		input        string
+		expectedText string
+		expected     *tgmd.FrontMatter
+	}{
+		{
+			name:         "YAML",
+			input:        "---\ndisable_link_preview: true # no preview\nmessage_thread_id: 42\nhashtags:\n  - release\n  - '#android 14'\n---\nBody.",
+			expectedText: "Body\\.\n\n\\#release \\#android\\_14\n",
+			expected: &tgmd.FrontMatter{
+				DisableLinkPreview: true,
+				MessageThreadID:    42,
+				Hashtags:           []string{"release", "android_14"},
+			},
+		},
+		{
+			name:         "TOML",
+			input:        "+++\ndisable_notification = true\nprotect_content = true\nhashtags = [\"news\", 'update']\n+++\nBody.",
+			expectedText: "Body\\.\n\n\\#news \\#update\n",
+			expected: &tgmd.FrontMatter{
+				DisableNotification: true,
+				ProtectContent:      true,
+				Hashtags:            []string{"news", "update"},
+			},
+		},
+		{
+			name:         "Empty",
+			input:        "---\n---\nBody.",
+			expectedText: "Body\\.",
+			expected:     &tgmd.FrontMatter{},
+		},
+		{
+			name:         "Unclosed",
+			input:        "---\nBody.",
+			expectedText: "──────────\nBody\\.\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tgmd.ConvertResult([]byte(tc.input), tgmd.WithFrontMatter())
+			if err != nil {
+				t.Fatalf("ConvertResult failed: %v", err)
+			}
+			if string(result.Text) != tc.expectedText {
+				t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", tc.expectedText, result.Text)
+			}
+			if !reflect.DeepEqual(result.FrontMatter, tc.expected) {
+				t.Errorf("FrontMatter mismatch:\nExpected: %+v\nGot:      %+v", tc.expected, result.FrontMatter)
+			}
+		})
+	}
+}
+
+func TestConvertResult_FrontMatterOverrides(t *testing.T) {
+	yaml := "---\nquote:\n  enable: true\n  expandable: true\nh1:\n  style: italic\n  prefix: \"» \"\n---\n# Title\n\nBody."
+	toml := "+++\n[quote]\nenable = true\nexpandable = true\n\n[h1]\nstyle = \"italic\"\nprefix = \"» \"\n+++\n# Title\n\nBody."
+	expectedText := "**>_» Title_\n>\n>Body\\.||"
+	expectedQuote := tgmd.QuoteConfig{Enable: true, Expandable: true}
+
+	for _, source := range []string{yaml, toml} {
+		result, err := tgmd.ConvertResult([]byte(source), tgmd.WithFrontMatter())
+		if err != nil {
+			t.Fatalf("ConvertResult failed: %v", err)
+		}
+		if string(result.Text) != expectedText {
+			t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", expectedText, result.Text)
+		}
+		fm := result.FrontMatter
+		if fm.Quote == nil || *fm.Quote != expectedQuote {
+			t.Errorf("Quote mismatch: expected %+v, got %+v", expectedQuote, fm.Quote)
+		}
+		if h1 := fm.Headings[0]; h1 == nil || h1.Prefix != "» " || string(h1.Style) != "_" {
+			t.Errorf("Unexpected h1 style: %+v", h1)
+		}
+		if fm.Headings[1] != nil {
+			t.Errorf("Expected h2 not to be overridden, got %+v", fm.Headings[1])
+		}
+	}
+}
+
+func TestConvert_FrontMatter(t *testing.T) {
+	source := "---\nhashtags: [a]\n---\nBody."
+	out, err := tgmd.Convert([]byte(source))
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if expected := "──────────\n*hashtags: \\[a\\]*\nBody\\.\n"; string(out) != expected {
+		t.Errorf("Expected front matter to be kept without WithFrontMatter:\nExpected: %q\nGot:      %q", expected, out)
+	}
+
+	out, err = tgmd.Convert([]byte(source), tgmd.WithFrontMatter())
+	if err != nil || string(out) != "Body\\.\n\n\\#a\n" {
+		t.Errorf("Unexpected output with WithFrontMatter: %q %v", out, err)
+	}
+
+	text, entities, err := tgmd.ConvertEntities([]byte(source), tgmd.WithFrontMatter())
+	if err != nil || text != "Body.\n\n#a\n" || len(entities) != 0 {
+		t.Errorf("Unexpected entities output: %q %+v %v", text, entities, err)
+	}
+
+	// Settings are not written back when the front matter is all there is.
+	out, err = tgmd.Convert([]byte("---\nquote:\n  enable: true\n---\n"), tgmd.WithFrontMatter())
+	if err != nil || len(out) != 0 {
+		t.Errorf("Expected no output for a document of front matter only, got %q %v", out, err)
+	}
+
+	invalid := []struct {
+		input    string
+		expected string
+	}{
+		{"---\ntitle: x\ndisable_notification: yes\n---\n", "tgmd: front matter line 3: disable_notification must be true or false"},
+		{"---\nmessage_thread_id: general\n---\n", "tgmd: front matter line 2: message_thread_id must be an integer"},
+		{"---\nh2:\n  style: fancy\n---\n", "tgmd: front matter line 3: h2.style must be bold, italic, underline, strikethrough, spoiler or none"},
+		{"+++\nhashtags\n+++\n", "tgmd: front matter line 2: expected \"key = value\""},
+	}
+	for _, tc := range invalid {
+		if _, err := tgmd.Convert([]byte(tc.input), tgmd.WithFrontMatter()); err == nil || err.Error() != tc.expected {
+			t.Errorf("Error mismatch for %q:\nExpected: %q\nGot:      %v", tc.input, tc.expected, err)
+		}
+	}
+}
+
+func TestWithHashtags(t *testing.T) {
+	out, err := tgmd.Convert([]byte("# Title\n\nBody."), tgmd.WithHashtags("#news", "big update", ""))
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if expected := "*Title*\n\nBody\\.\n\n\\#news \\#big\\_update\n"; string(out) != expected {
+		t.Errorf("Output mismatch:\nExpected: %q\nGot:      %q", expected, out)
+	}
+}
+
+func TestConvertResult_Buttons(t *testing.T) {
+	testCases := []struct {
+		name         string
+		input        string
+		expectedText string
+		expected     [][]tgmd.InlineKeyboardButton
+	}{
+		{
+			name:         "Marker List",
+			input:        "Release **2.0** is out.\n\n<!-- buttons -->\n- [Download](https://example.com/dl) [Mirror](https://mirror.example.com)\n- [Vote](callback:vote_up)\n",
+			expectedText: "Release *2\\.0* is out\\.",
+			expected: [][]tgmd.InlineKeyboardButton{
+				{
+					{Text: "Download", URL: "https://example.com/dl", Position: tgmd.Position{Offset: 45, Line: 4, Column: 4}},
+					{Text: "Mirror", URL: "https://mirror.example.com", Position: tgmd.Position{Offset: 80, Line: 4, Column: 39}},
+				},
+				{
+					{Text: "Vote", CallbackData: "vote_up", Position: tgmd.Position{Offset: 119, Line: 5, Column: 4}},
+				},
+			},
+		},
+		{
+			name:         "Inline Buttons",
+			input:        "Get it:\n[[Download](https://x.y)]{.button} [[Notes](https://n.y)]{.button}\n[[Like](callback:like)]{.button}",
+			expectedText: "Get it:",
+			expected: [][]tgmd.InlineKeyboardButton{
+				{
+					{Text: "Download", URL: "https://x.y", Position: tgmd.Position{Offset: 8, Line: 2, Column: 1}},
+					{Text: "Notes", URL: "https://n.y", Position: tgmd.Position{Offset: 43, Line: 2, Column: 36}},
+				},
+				{
+					{Text: "Like", CallbackData: "like", Position: tgmd.Position{Offset: 75, Line: 3, Column: 1}},
+				},
+			},
+		},
+		{
+			name:         "Inline Button Between Words",
+			input:        "Text [[Inline](https://x.y)]{.button} after.",
+			expectedText: "Text after\\.",
+			expected: [][]tgmd.InlineKeyboardButton{
+				{{Text: "Inline", URL: "https://x.y", Position: tgmd.Position{Offset: 5, Line: 1, Column: 6}}},
+			},
+		},
+		{
+			name:         "Paragraph of Buttons",
+			input:        "[[Only](https://x.y)]{.button}\n\nNext",
+			expectedText: "Next",
+			expected: [][]tgmd.InlineKeyboardButton{
+				{{Text: "Only", URL: "https://x.y", Position: tgmd.Position{Offset: 0, Line: 1, Column: 1}}},
+			},
+		},
+		{
+			name:         "Marker Without List",
+			input:        "<!-- buttons -->\n\n[Link](https://x.y) [not](https://n.y){.button}",
+			expectedText: "[Link](https://x.y) [not](https://n.y)\\{\\.button\\}",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tgmd.ConvertResult([]byte(tc.input), tgmd.WithButtons())
+			if err != nil {
+				t.Fatalf("ConvertResult failed: %v", err)
+			}
+			if string(result.Text) != tc.expectedText {
+				t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", tc.expectedText, result.Text)
+			}
+			var got [][]tgmd.InlineKeyboardButton
+			if result.Keyboard != nil {
+				got = result.Keyboard.InlineKeyboard
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Keyboard mismatch:\nExpected: %+v\nGot:      %+v", tc.expected, got)
+			}
+			if result.Losses != nil && tc.expected != nil {
+				t.Errorf("Unexpected losses: %v", result.Losses)
+			}
+		})
+	}
+
+	source := "<!-- buttons -->\n- [Download](https://example.com)\n"
+	if out, err := tgmd.Convert([]byte(source)); err != nil || string(out) != "  • [Download](https://example.com)" {
+		t.Errorf("Expected buttons to be kept without WithButtons, got %q %v", out, err)
+	}
+}
+
+func TestConvertResult_InvalidButtons(t *testing.T) {
+	source := "[[Bad](ftp://x)]{.button} [[Vote](callback:" + strings.Repeat("x", 65) + ")]{.button}\n" +
+		"[[](https://x.y)]{.button}\n" + strings.Repeat("[[n](https://x.y)]{.button} ", 9)
+	_, err := tgmd.ConvertResult([]byte(source), tgmd.WithButtons())
+	var keyboardErr *tgmd.KeyboardError
+	if !errors.As(err, &keyboardErr) {
+		t.Fatalf("Expected a *KeyboardError, got %v", err)
+	}
+	var got []string
+	for _, d := range keyboardErr.Diagnostics {
+		got = append(got, d.String())
+	}
+	expected := []string{
+		"1:1: button \"Bad\" URL \"ftp://x\" must be an http, https or tg URL",
+		"1:27: button \"Vote\" callback data is 65 bytes, over the limit of 64",
+		"2:1: button has no text",
+		"3:225: row has 9 buttons, over the limit of 8",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Diagnostics mismatch:\nExpected: %q\nGot:      %q", expected, got)
+	}
+	if !strings.HasPrefix(err.Error(), "tgmd: invalid keyboard: 1:1: ") || !strings.HasSuffix(err.Error(), "(and 3 more)") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	keyboard := &tgmd.InlineKeyboardMarkup{InlineKeyboard: [][]tgmd.InlineKeyboardButton{
+		{{Text: "Open", URL: "tg://resolve?domain=example"}, {Text: "Vote", CallbackData: "up"}},
+	}}
+	if err := keyboard.Validate(); err != nil {
+		t.Errorf("Valid keyboard failed: %v", err)
+	}
+}
+
+func TestConvertResult_LinkPreview(t *testing.T) {
+	notes := "See [docs](https://docs.example.com).\n\n## Download\n\nGet [it](https://dl.example.com) or <https://mirror.example.com>.\n\n## Notes\n\n[n](https://notes.example.com)"
+	testCases := []struct {
+		name         string
+		input        string
+		config       tgmd.LinkPreviewConfig
+		expectedText string
+		expected     *tgmd.LinkPreviewOptions
+	}{
+		{
+			name:         "Marker",
+			input:        "See [docs](https://docs.example.com) and [this](https://x.y){preview}.",
+			expectedText: "See [docs](https://docs.example.com) and [this](https://x.y)\\.",
+			expected:     &tgmd.LinkPreviewOptions{URL: "https://x.y"},
+		},
+		{
+			name:         "Title",
+			input:        "[a](https://a.example.com) [b](https://b.example.com \"preview\") [c](https://c.example.com){preview}",
+			config:       tgmd.LinkPreviewConfig{ShowAboveText: true},
+			expectedText: "[a](https://a.example.com) [b](https://b.example.com) [c](https://c.example.com)",
+			expected:     &tgmd.LinkPreviewOptions{URL: "https://b.example.com", ShowAboveText: true},
+		},
+		{
+			name:         "Heading",
+			input:        notes,
+			config:       tgmd.LinkPreviewConfig{Heading: "download", PreferLargeMedia: true},
+			expectedText: "See [docs](https://docs.example.com)\\.\n\n*Download*\n\nGet [it](https://dl.example.com) or [https://mirror\\.example\\.com](https://mirror.example.com)\\.\n\n*Notes*\n\n[n](https://notes.example.com)\n",
+			expected:     &tgmd.LinkPreviewOptions{URL: "https://dl.example.com", PreferLargeMedia: true},
+		},
+		{
+			name:         "Subsection",
+			input:        "## Download\n\n### Mirrors\n\n<https://mirror.example.com>\n\n## Notes\n\n[n](https://notes.example.com)",
+			config:       tgmd.LinkPreviewConfig{Heading: "Download"},
+			expectedText: "*Download*\n\n*Mirrors*\n\n[https://mirror\\.example\\.com](https://mirror.example.com)\n\n*Notes*\n\n[n](https://notes.example.com)\n",
+			expected:     &tgmd.LinkPreviewOptions{URL: "https://mirror.example.com"},
+		},
+		{
+			name:         "Section Without Links",
+			input:        "## Download\n\nSoon.\n\n## Notes\n\n[n](https://notes.example.com)",
+			config:       tgmd.LinkPreviewConfig{Heading: "Download"},
+			expectedText: "*Download*\n\nSoon\\.\n\n*Notes*\n\n[n](https://notes.example.com)\n",
+		},
+		{
+			name:         "Mentions Are Not Previewed",
+			input:        "[Jane](tg://user?id=1){preview}",
+			expectedText: "[Jane](tg://user?id=1)\\{preview\\}",
+		},
+		{
+			name:         "Disabled",
+			input:        "[this](https://x.y){preview}",
+			config:       tgmd.LinkPreviewConfig{Disable: true, PreferLargeMedia: true},
+			expectedText: "[this](https://x.y)",
+			expected:     &tgmd.LinkPreviewOptions{IsDisabled: true},
+		},
+		{
+			name:         "Disabled by Front Matter",
+			input:        "---\ndisable_link_preview: true\n---\n[this](https://x.y){preview}",
+			config:       tgmd.LinkPreviewConfig{ShowAboveText: true},
+			expectedText: "[this](https://x.y)",
+			expected:     &tgmd.LinkPreviewOptions{IsDisabled: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tgmd.ConvertResult([]byte(tc.input), tgmd.WithLinkPreview(tc.config), tgmd.WithFrontMatter())
+			if err != nil {
+				t.Fatalf("ConvertResult failed: %v", err)
+			}
+			if string(result.Text) != tc.expectedText {
+				t.Errorf("Text mismatch:\nExpected: %q\nGot:      %q", tc.expectedText, result.Text)
+			}
+			if !reflect.DeepEqual(result.LinkPreview, tc.expected) {
+				t.Errorf("LinkPreview mismatch:\nExpected: %+v\nGot:      %+v", tc.expected, result.LinkPreview)
+			}
+		})
+	}
+
+	result, err := tgmd.ConvertResult([]byte("[this](https://x.y){preview}"))
+	if err != nil || string(result.Text) != "[this](https://x.y)\\{preview\\}" || result.LinkPreview != nil {
+		t.Errorf("Expected markers to be kept without WithLinkPreview, got %q %+v %v", result.Text, result.LinkPreview, err)
+	}
+}
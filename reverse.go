@@ -0,0 +1,508 @@
+package tgmd
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// ParseMarkdownV2 decodes a MarkdownV2 message into its plain text and the
+// entities describing its formatting, undoing the escapes written by
+// Convert. Messages Telegram would reject return a *ValidationError.
+func ParseMarkdownV2(message []byte) (string, []Entity, error) {
+	plain, entities, err := parseMarkdownV2(message)
+	entities = slices.DeleteFunc(entities, func(e Entity) bool { return e.Length == 0 })
+	return plain, entities, err
+}
+
+// parseMarkdownV2 is ParseMarkdownV2 keeping the empty pre entities, which
+// Telegram drops but which are code blocks of the document.
+func parseMarkdownV2(message []byte) (string, []Entity, error) {
+	if err := Validate(message); err != nil {
+		return "", nil, err
+	}
+	var (
+		plain    []byte
+		offset   int
+		stack    []mdv2Token
+		starts   []int
+		entities []Entity
+	)
+	for _, t := range scanMarkdownV2(message) {
+		switch t.kind {
+		case mdv2Text:
+			value := message[t.start:t.end]
+			switch {
+			case value[0] == '\r':
+				continue
+			case value[0] == SlashChar.Byte() && len(value) > 1:
+				value = value[1:]
+			}
+			plain = append(plain, value...)
+			offset += utf16Len(value)
+		case mdv2Open:
+			stack = append(stack, t)
+			starts = append(starts, offset)
+		case mdv2Close:
+			i := len(stack) - 1
+			for i > 0 && stack[i].entity != t.entity {
+				i--
+			}
+			open, start := stack[i], starts[i]
+			stack, starts = slices.Delete(stack, i, i+1), slices.Delete(starts, i, i+1)
+
+			e := Entity{Type: t.entity, Offset: start}
+			switch t.entity {
+			case EntityTextLink:
+				e = linkEntity(unescapeMarkdownV2(open.close[2 : len(open.close)-1]))
+				e.Offset = start
+			case EntityCustomEmoji:
+				e.CustomEmojiID, _ = customEmojiID(unescapeMarkdownV2(open.close[2 : len(open.close)-1]))
+			case EntityPre:
+				e.Language = string(bytes.TrimSpace(open.open[3:]))
+				// The line break before the closing fence belongs to the markup.
+				if bytes.HasSuffix(plain, NewLineChar.Bytes(1)) && offset > start {
+					plain = plain[:len(plain)-1]
+					offset--
+				}
+			}
+			e.Length = offset - start
+			if e.Length > 0 || e.Type == EntityPre {
+				entities = append(entities, e)
+			}
+		}
+	}
+	sortEntities(entities)
+	return string(plain), entities, nil
+}
+
+// unescapeMarkdownV2 removes the backslashes escaping characters in b.
+func unescapeMarkdownV2(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == SlashChar.Byte() && i+1 < len(b) {
+			i++
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
+
+// ParseEntities rebuilds a goldmark document from plain text and the
+// entities describing its formatting. Pre and quote entities become code
+// blocks and blockquotes, blank lines separate paragraphs and lines
+// starting with a bullet, a check box or a number become lists. The
+// returned source holds the segments the document refers to.
+func ParseEntities(plain string, entities []Entity) (ast.Node, []byte) {
+	p := newEntityParser(plain, entities)
+	doc := ast.NewDocument()
+	p.blocks(doc, 0, len(plain))
+	return doc, p.source
+}
+
+// MarkdownV2AST parses a MarkdownV2 message into a goldmark document, see
+// ParseMarkdownV2 and ParseEntities.
+func MarkdownV2AST(message []byte) (ast.Node, []byte, error) {
+	plain, entities, err := parseMarkdownV2(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, source := ParseEntities(plain, entities)
+	return doc, source, nil
+}
+
+// entitySpan is an entity located by byte offsets in the text.
+type entitySpan struct {
+	Entity
+	start, end int
+	used       bool
+}
+
+// entityParser builds goldmark nodes from text and entities.
+type entityParser struct {
+	// source starts with the text and grows with the bytes nodes need
+	// that are not part of it, such as code block languages.
+	source []byte
+	spans  []*entitySpan
+}
+
+func newEntityParser(plain string, entities []Entity) *entityParser {
+	// units[i] is the byte offset of UTF-16 code unit i.
+	units := make([]int, 0, len(plain)+1)
+	for i, r := range plain {
+		units = append(units, i)
+		if r >= 0x10000 {
+			units = append(units, i)
+		}
+	}
+	units = append(units, len(plain))
+	at := func(u int) int {
+		return units[min(max(u, 0), len(units)-1)]
+	}
+
+	p := &entityParser{source: []byte(plain)}
+	for _, e := range entities {
+		s := &entitySpan{Entity: e, start: at(e.Offset), end: at(e.Offset + e.Length)}
+		if s.start < s.end || (s.Type == EntityPre && s.start == s.end) {
+			p.spans = append(p.spans, s)
+		}
+	}
+	sortSpans(p.spans)
+	return p
+}
+
+// sortSpans orders spans by start, outer spans first.
+func sortSpans(spans []*entitySpan) {
+	slices.SortStableFunc(spans, func(a, b *entitySpan) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+}
+
+// isBlockEntity reports whether entities of type t are rendered as blocks.
+func isBlockEntity(t EntityType) bool {
+	return t == EntityPre || t == EntityBlockquote || t == EntityExpandableBlockquote
+}
+
+// appendSource adds b past the end of the text and returns its segment.
+func (p *entityParser) appendSource(b []byte) text.Segment {
+	start := len(p.source)
+	p.source = append(p.source, b...)
+	return text.NewSegment(start, len(p.source))
+}
+
+// blockAt returns the unused block entity starting at pos and ending
+// before end.
+func (p *entityParser) blockAt(pos, end int) *entitySpan {
+	for _, s := range p.spans {
+		if !s.used && isBlockEntity(s.Type) && s.start == pos && s.end <= end {
+			return s
+		}
+	}
+	return nil
+}
+
+// nextBlock returns the start of the first unused block entity in
+// (pos, end), or end.
+func (p *entityParser) nextBlock(pos, end int) int {
+	for _, s := range p.spans {
+		if !s.used && isBlockEntity(s.Type) && s.start > pos && s.start < end && s.end <= end {
+			return s.start
+		}
+	}
+	return end
+}
+
+// lineEnd returns the end of the line starting at pos.
+func (p *entityParser) lineEnd(pos, end int) int {
+	if i := bytes.IndexByte(p.source[pos:end], NewLineChar.Byte()); i >= 0 {
+		return pos + i
+	}
+	return end
+}
+
+// blocks appends the blocks found in source[start:end] to parent. Block
+// entities are looked up first, so that empty code blocks and code blocks
+// starting with a blank line are kept.
+func (p *entityParser) blocks(parent ast.Node, start, end int) {
+	pos, newLines := start, 0
+	for {
+		var block ast.Node
+		switch s := p.blockAt(pos, end); {
+		case s != nil:
+			block, pos = p.blockEntity(s), s.end
+		case pos >= end:
+			return
+		case p.source[pos] == NewLineChar.Byte():
+			newLines++
+			pos++
+			continue
+		case listMarkerOf(p.source[pos:p.lineEnd(pos, end)]) != nil:
+			block, pos = p.list(pos, end)
+		default:
+			block, pos = p.paragraph(pos, end)
+		}
+		block.SetBlankPreviousLines(parent.HasChildren() && newLines > 1)
+		parent.AppendChild(parent, block)
+		newLines = 0
+	}
+}
+
+// blockEntity turns a pre or quote entity into a block.
+func (p *entityParser) blockEntity(s *entitySpan) ast.Node {
+	s.used = true
+	if s.Type != EntityPre {
+		quote := ast.NewBlockquote()
+		if s.Type == EntityExpandableBlockquote {
+			quote.SetAttribute(expandableAttribute, true)
+		}
+		p.blocks(quote, s.start, s.end)
+		return quote
+	}
+
+	var info *ast.Text
+	if s.Language != "" {
+		info = ast.NewTextSegment(p.appendSource([]byte(s.Language)))
+	}
+	code := ast.NewFencedCodeBlock(info)
+	for pos := s.start; pos < s.end; {
+		end := p.lineEnd(pos, s.end)
+		if end < s.end {
+			code.Lines().Append(text.NewSegment(pos, end+1))
+		} else {
+			code.Lines().Append(text.NewSegment(pos, end))
+			code.Lines().Append(p.appendSource(NewLineChar.Bytes(1)))
+		}
+		pos = end + 1
+	}
+	return code
+}
+
+// paragraph reads a paragraph starting at pos. It ends at a blank line, a
+// block entity or a list line.
+func (p *entityParser) paragraph(pos, end int) (ast.Node, int) {
+	end = p.nextBlock(pos, end)
+	para := ast.NewParagraph()
+	start, stop := pos, pos
+	for pos < end {
+		lineEnd := p.lineEnd(pos, end)
+		if lineEnd == pos || (pos > start && listMarkerOf(p.source[pos:lineEnd]) != nil) {
+			break
+		}
+		para.Lines().Append(text.NewSegment(pos, lineEnd))
+		stop, pos = lineEnd, lineEnd+1
+	}
+	p.inlines(para, start, stop)
+	return para, stop
+}
+
+// listMarker is the bullet or number starting a list line.
+type listMarker struct {
+	indent  int
+	width   int
+	ordered bool
+	number  int
+	// task is 0 for plain items, 1 for unchecked and 2 for checked ones.
+	task int
+}
+
+// listBullets are the bullets recognised at the start of a line.
+var listBullets = []rune{'•', '‣', '⁃', '◦', '▪'}
+
+// listMarkerOf parses the list marker at the start of line, or returns nil.
+// Numbers only start a list item when indented, as Convert writes them, so
+// that text such as "2. Go on" typed in a message stays a paragraph.
+func listMarkerOf(line []byte) *listMarker {
+	m := &listMarker{}
+	for m.indent < len(line) && line[m.indent] == SpaceChar.Byte() {
+		m.indent++
+	}
+	rest := line[m.indent:]
+	digits := 0
+	for digits < len(rest) && digits < 9 && rest[digits] >= '0' && rest[digits] <= '9' {
+		m.number = m.number*10 + int(rest[digits]-'0')
+		digits++
+	}
+	switch {
+	case m.indent > 0 && digits > 0 && len(rest) > digits+1 &&
+		(rest[digits] == '.' || rest[digits] == ')') && rest[digits+1] == SpaceChar.Byte():
+		m.ordered = true
+		rest = rest[digits+2:]
+	case bytes.HasPrefix(rest, checkBoxBytes(defaultConfig.taskCheckBoxes[0])),
+		bytes.HasPrefix(rest, checkBoxBytes(defaultConfig.taskCheckBoxes[1])):
+	default:
+		ok := false
+		for _, b := range listBullets {
+			if prefix := checkBoxBytes(b); bytes.HasPrefix(rest, prefix) {
+				rest, ok = rest[len(prefix):], true
+				break
+			}
+		}
+		if !ok {
+			return nil
+		}
+	}
+	for i, glyph := range defaultConfig.taskCheckBoxes {
+		if prefix := checkBoxBytes(glyph); bytes.HasPrefix(rest, prefix) {
+			m.task = i + 1
+			rest = rest[len(prefix):]
+		}
+	}
+	m.width = len(line) - len(rest)
+	return m
+}
+
+// checkBoxBytes returns a bullet or check box glyph followed by a space.
+func checkBoxBytes(glyph rune) []byte {
+	return append([]byte(string(glyph)), SpaceChar.Byte())
+}
+
+// list reads the consecutive list lines starting at pos, nesting them by
+// indentation. Blank lines followed by another list line stay in the list.
+func (p *entityParser) list(pos, end int) (ast.Node, int) {
+	end = p.nextBlock(pos, end)
+	type level struct {
+		indent int
+		list   *ast.List
+	}
+	var stack []level
+	stop := pos
+	for pos < end {
+		next := pos
+		for next < end && p.source[next] == NewLineChar.Byte() {
+			next++
+		}
+		lineEnd := p.lineEnd(next, end)
+		m := listMarkerOf(p.source[next:lineEnd])
+		if m == nil {
+			break
+		}
+		blank := next > pos
+		pos = next
+		for len(stack) > 1 && stack[len(stack)-1].indent > m.indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 || stack[len(stack)-1].indent < m.indent {
+			marker := byte('-')
+			if m.ordered {
+				marker = '.'
+			}
+			list := ast.NewList(marker)
+			list.IsTight = true
+			if m.ordered {
+				list.Start = m.number
+			}
+			if len(stack) > 0 {
+				item := stack[len(stack)-1].list.LastChild()
+				item.AppendChild(item, list)
+				list.SetBlankPreviousLines(blank)
+				blank = false
+			}
+			stack = append(stack, level{indent: m.indent, list: list})
+		}
+
+		item := ast.NewListItem(m.width)
+		item.SetBlankPreviousLines(blank)
+		block := ast.NewTextBlock()
+		if m.task > 0 {
+			block.AppendChild(block, ext.NewTaskCheckBox(m.task == 2))
+		}
+		block.Lines().Append(text.NewSegment(pos+m.width, lineEnd))
+		p.inlines(block, pos+m.width, lineEnd)
+		item.AppendChild(item, block)
+		list := stack[len(stack)-1].list
+		list.AppendChild(list, item)
+		stop, pos = lineEnd, lineEnd+1
+	}
+	return stack[0].list, stop
+}
+
+// inlines appends the text and inline entities of source[start:end] to
+// parent. Entities crossing each other are split so they nest.
+func (p *entityParser) inlines(parent ast.Node, start, end int) {
+	var spans []*entitySpan
+	for _, s := range p.spans {
+		if isBlockEntity(s.Type) {
+			continue
+		}
+		clipped := *s
+		clipped.start, clipped.end = max(s.start, start), min(s.end, end)
+		if clipped.start < clipped.end {
+			spans = append(spans, &clipped)
+		}
+	}
+	p.nest(parent, start, end, spans)
+}
+
+// nest appends source[start:end] to parent, wrapping the parts covered by
+// spans, which are sorted and lie within the range.
+func (p *entityParser) nest(parent ast.Node, start, end int, spans []*entitySpan) {
+	pos := start
+	for len(spans) > 0 {
+		s := spans[0]
+		var inner, rest []*entitySpan
+		for _, o := range spans[1:] {
+			switch {
+			case o.start >= s.end:
+				rest = append(rest, o)
+			case o.end <= s.end:
+				inner = append(inner, o)
+			default:
+				head, tail := *o, *o
+				head.end, tail.start = s.end, s.end
+				inner = append(inner, &head)
+				rest = append(rest, &tail)
+			}
+		}
+		sortSpans(rest)
+		spans = rest
+
+		p.text(parent, pos, s.start)
+		pos = s.end
+		node := entityNode(s.Entity)
+		switch {
+		case node == nil:
+			p.nest(parent, s.start, s.end, inner)
+			continue
+		case node.Kind() == ast.KindCodeSpan:
+			node.AppendChild(node, ast.NewTextSegment(text.NewSegment(s.start, s.end)))
+		default:
+			p.nest(node, s.start, s.end, inner)
+		}
+		parent.AppendChild(parent, node)
+	}
+	p.text(parent, pos, end)
+}
+
+// text appends source[start:end] to parent as text nodes, one per line.
+func (p *entityParser) text(parent ast.Node, start, end int) {
+	for start < end {
+		lineEnd := p.lineEnd(start, end)
+		t := ast.NewTextSegment(text.NewSegment(start, lineEnd))
+		// The text holds no Markdown escapes to resolve.
+		t.SetRaw(true)
+		t.SetSoftLineBreak(lineEnd < end)
+		parent.AppendChild(parent, t)
+		start = lineEnd + 1
+	}
+}
+
+// entityNode returns the inline node for an entity, or nil for entity
+// types that have no Markdown counterpart.
+func entityNode(e Entity) ast.Node {
+	switch e.Type {
+	case EntityBold:
+		return ast.NewEmphasis(2)
+	case EntityItalic:
+		return ast.NewEmphasis(1)
+	case EntityUnderline:
+		return NewUnderline()
+	case EntityStrikethrough:
+		return ext.NewStrikethrough()
+	case EntitySpoiler:
+		return NewHidden()
+	case EntityCode:
+		return ast.NewCodeSpan()
+	case EntityTextLink:
+		link := ast.NewLink()
+		link.Destination = []byte(e.URL)
+		return link
+	case EntityTextMention:
+		link := ast.NewLink()
+		if e.User != nil {
+			link.Destination = strconv.AppendInt([]byte(mentionURL), e.User.ID, 10)
+		}
+		return link
+	case EntityCustomEmoji:
+		link := ast.NewLink()
+		link.Destination = []byte(customEmojiURL + e.CustomEmojiID)
+		return ast.NewImage(link)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package tgmd_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+var roundTripCases = []struct {
+	name  string
+	input string
+	opts  []tgmd.Option
+}{
+	{name: "Paragraphs", input: "First line\nsecond line.\n\nNext paragraph (with 1.5 + 2 = 3.5!)"},
+	{name: "Formatting", input: "**bold** *italic* ++under++ ~~strike~~ ||secret|| `a*b`"},
+	{name: "Nested Formatting", input: "**bold *both* ~~all~~**"},
+	{name: "Escaped Markup", input: "a\\*b \\_c\\_ [d] #e 1\\. f \\\\ g"},
+	{name: "Line Start Markers", input: "\\# not a heading\n\n\\- not a list\n\n2\\. not a list"},
+	{name: "Links", input: "See [the docs](https://example.com/a_(b)) and [**bold** link](https://example.com/?q=1)."},
+	{name: "Code Span With Backquotes", input: "Use ``a `b` c`` here."},
+	{name: "Code Block", input: "Intro:\n\n```go\nfunc main() {\n\tprintln(\"`hi`\\n\")\n}\n```\n\nOutro."},
+	{name: "Headings", input: "# Title\n\nText\n\n#### Sub"},
+	{name: "Lists", input: "- one\n- two\n  - nested *item*\n    - deeper\n- three"},
+	{name: "Ordered Lists", input: "3. three\n4. four\n   - sub"},
+	{name: "Loose Nested List", input: "1.  foo\n\n    - bar\n\n      - baz\n2. qux"},
+	{name: "Task List", input: "- [ ] todo\n- [x] done"},
+	{name: "Blockquote", input: "> quoted **text**\n> second line\n\nafter"},
+	{name: "Expandable Blockquote", input: "> [!expand]\n> log **line**\n>\n> - item\n\nafter"},
+	{name: "Expandable Blockquote Ending with Code", input: "> [!expand]\n> Log:\n> ```\n> a_b\n> ```"},
+	{name: "Document Quote", input: "Line 1\n\nLine 2", opts: []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true})}},
+	{name: "Quoted Code Block", input: "> Log:\n> ```\n> a > b\n> ```\n> after"},
+	{name: "Document Quote with Code", input: "Intro\n\n```\n> foo\n```", opts: []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true})}},
+	{name: "Underscore Underline", input: "__under__ _it_", opts: []tgmd.Option{tgmd.WithUnderlineSyntax(tgmd.UnderlineUnderscore)}},
+	{name: "Emoji", input: "😀 **bold 😀** after"},
+	{name: "Custom Emoji and Mentions", input: "Hi ![👍](tg://emoji?id=5368324170671202286) [Jane **Doe**](tg://user?id=123)"},
+}
+
+func TestMarkdownV2ToCommonMark_RoundTrip(t *testing.T) {
+	for _, tc := range roundTripCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message, err := tgmd.Convert([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			markdown, err := tgmd.MarkdownV2ToCommonMark(message, tc.opts...)
+			if err != nil {
+				t.Fatalf("MarkdownV2ToCommonMark failed: %v", err)
+			}
+			got, err := tgmd.Convert(markdown, tc.opts...)
+			if err != nil {
+				t.Fatalf("Convert of %q failed: %v", markdown, err)
+			}
+			if string(got) != string(message) {
+				t.Errorf("Round trip mismatch through %q:\nExpected: %q\nGot:      %q", markdown, message, got)
+			}
+		})
+	}
+}
+
+func TestEntitiesToCommonMark_RoundTrip(t *testing.T) {
+	for _, tc := range roundTripCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plain, entities, err := tgmd.ConvertEntities([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("ConvertEntities failed: %v", err)
+			}
+			markdown, err := tgmd.EntitiesToCommonMark(plain, entities, tc.opts...)
+			if err != nil {
+				t.Fatalf("EntitiesToCommonMark failed: %v", err)
+			}
+			gotPlain, gotEntities, err := tgmd.ConvertEntities(markdown, tc.opts...)
+			if err != nil {
+				t.Fatalf("ConvertEntities of %q failed: %v", markdown, err)
+			}
+			if gotPlain != plain || !reflect.DeepEqual(gotEntities, entities) {
+				t.Errorf("Round trip mismatch through %q:\nExpected: %q %+v\nGot:      %q %+v",
+					markdown, plain, entities, gotPlain, gotEntities)
+			}
+		})
+	}
+}
+
+func TestParseMarkdownV2_MatchesConvertEntities(t *testing.T) {
+	for _, tc := range roundTripCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message, err := tgmd.Convert([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			gotPlain, gotEntities, err := tgmd.ParseMarkdownV2(message)
+			if err != nil {
+				t.Fatalf("ParseMarkdownV2 failed: %v", err)
+			}
+			plain, entities, err := tgmd.ConvertEntities([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("ConvertEntities failed: %v", err)
+			}
+			if gotPlain != plain || !reflect.DeepEqual(gotEntities, entities) {
+				t.Errorf("Mismatch for %q:\nExpected: %q %+v\nGot:      %q %+v",
+					message, plain, entities, gotPlain, gotEntities)
+			}
+		})
+	}
+}
+
+func TestMarkdownV2ToCommonMark_Example(t *testing.T) {
+	source, err := os.ReadFile("example/source.md")
+	if err != nil {
+		t.Fatalf("Failed to read source.md: %v", err)
+	}
+	message, err := tgmd.Convert(source)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	markdown, err := tgmd.MarkdownV2ToCommonMark(message)
+	if err != nil {
+		t.Fatalf("MarkdownV2ToCommonMark failed: %v", err)
+	}
+	got, err := tgmd.Convert(markdown)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if string(got) != string(message) {
+		t.Errorf("Round trip mismatch through:\n%s\nExpected: %q\nGot:      %q", markdown, message, got)
+	}
+}
+
+func TestMarkdownV2ToCommonMark_EmptyCodeBlock(t *testing.T) {
+	for _, input := range []string{"```\n```", "Intro\n\n```go\n```\n\nOutro"} {
+		message, err := tgmd.Convert([]byte(input))
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		markdown, err := tgmd.MarkdownV2ToCommonMark(message)
+		if err != nil {
+			t.Fatalf("MarkdownV2ToCommonMark failed: %v", err)
+		}
+		got, err := tgmd.Convert(markdown)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		if string(got) != string(message) {
+			t.Errorf("Round trip mismatch through %q:\nExpected: %q\nGot:      %q", markdown, message, got)
+		}
+	}
+	if _, entities, _ := tgmd.ParseMarkdownV2([]byte("```\n```")); len(entities) != 0 {
+		t.Errorf("Expected no entities for an empty code block, got %+v", entities)
+	}
+}
+
+func TestParseMarkdownV2_Invalid(t *testing.T) {
+	if _, _, err := tgmd.ParseMarkdownV2([]byte("*open")); err == nil {
+		t.Error("Expected an error for an unclosed entity")
+	}
+}
@@ -0,0 +1,346 @@
+package tgmd
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// mdv2TokenKind classifies the pieces of a MarkdownV2 message.
+type mdv2TokenKind int
+
+const (
+	// mdv2Text is a single visible character, possibly escaped.
+	mdv2Text mdv2TokenKind = iota
+	// mdv2Open starts an entity.
+	mdv2Open
+	// mdv2Close ends the entity opened last.
+	mdv2Close
+	// mdv2QuoteMark is the '>' continuing a quote on a new line.
+	mdv2QuoteMark
+)
+
+// mdv2Token is a piece of a MarkdownV2 message located at text[start:end].
+type mdv2Token struct {
+	kind   mdv2TokenKind
+	start  int
+	end    int
+	entity EntityType
+	// open and close hold the markup that opens and closes the entity,
+	// so it can be reopened after being interrupted.
+	open  []byte
+	close []byte
+	// width is the visible length in UTF-16 code units.
+	width int
+}
+
+// isSpace reports whether the token is a visible space or newline.
+func (t mdv2Token) isSpace(text []byte) bool {
+	return t.kind == mdv2Text && t.end-t.start == 1 &&
+		(text[t.start] == SpaceChar.Byte() || text[t.start] == NewLineChar.Byte())
+}
+
+// isNewLine reports whether the token is a visible newline.
+func (t mdv2Token) isNewLine(text []byte) bool {
+	return t.kind == mdv2Text && t.end-t.start == 1 && text[t.start] == NewLineChar.Byte()
+}
+
+// mdv2Scanner splits a MarkdownV2 message into tokens following the
+// Telegram entity grammar. It is lenient: reserved characters that cannot
+// start an entity are reported as text.
+type mdv2Scanner struct {
+	text   []byte
+	pos    int
+	stack  []mdv2Token
+	tokens []mdv2Token
+}
+
+// scanMarkdownV2 tokenizes text. Entities left open at the end of the text
+// are closed with empty tokens.
+func scanMarkdownV2(text []byte) []mdv2Token {
+	s := &mdv2Scanner{text: text}
+	s.scan()
+	return s.tokens
+}
+
+func (s *mdv2Scanner) top() EntityType {
+	if len(s.stack) == 0 {
+		return ""
+	}
+	return s.stack[len(s.stack)-1].entity
+}
+
+func (s *mdv2Scanner) inQuote() bool {
+	for _, t := range s.stack {
+		if t.entity == EntityBlockquote || t.entity == EntityExpandableBlockquote {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mdv2Scanner) emit(t mdv2Token) {
+	s.tokens = append(s.tokens, t)
+}
+
+func (s *mdv2Scanner) open(entity EntityType, size int, closer []byte) {
+	t := mdv2Token{
+		kind:   mdv2Open,
+		start:  s.pos,
+		end:    s.pos + size,
+		entity: entity,
+		open:   s.text[s.pos : s.pos+size],
+		close:  closer,
+	}
+	s.stack = append(s.stack, t)
+	s.emit(t)
+	s.pos += size
+}
+
+// close ends the innermost entity.
+func (s *mdv2Scanner) close(size int) {
+	s.closeAt(len(s.stack)-1, size)
+}
+
+// closeAt ends the entity at index i of the stack, which is not the
+// innermost one if entities cross.
+func (s *mdv2Scanner) closeAt(i, size int) {
+	t := s.stack[i]
+	s.stack = append(s.stack[:i], s.stack[i+1:]...)
+	s.emit(mdv2Token{kind: mdv2Close, start: s.pos, end: s.pos + size, entity: t.entity})
+	s.pos += size
+}
+
+// toggle closes entity if it is open and opens it otherwise.
+func (s *mdv2Scanner) toggle(entity EntityType, size int) {
+	for i := len(s.stack) - 1; i >= 0; i-- {
+		if s.stack[i].entity == entity {
+			s.closeAt(i, size)
+			return
+		}
+	}
+	s.open(entity, size, s.text[s.pos:s.pos+size])
+}
+
+// char emits the character at pos as text, skipping size bytes of markup
+// (such as an escaping backslash) in front of it.
+func (s *mdv2Scanner) char(size int) {
+	start := s.pos
+	s.pos += size
+	if s.pos >= len(s.text) {
+		s.pos = len(s.text)
+		s.emit(mdv2Token{kind: mdv2Text, start: start, end: s.pos})
+		return
+	}
+	r, n := utf8.DecodeRune(s.text[s.pos:])
+	s.pos += n
+	width := 1
+	if r >= 0x10000 {
+		width = 2
+	}
+	s.emit(mdv2Token{kind: mdv2Text, start: start, end: s.pos, width: width})
+}
+
+func (s *mdv2Scanner) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(s.text[s.pos:], StringToBytes(prefix))
+}
+
+func (s *mdv2Scanner) scan() {
+	for s.pos < len(s.text) {
+		if s.pos == 0 || s.text[s.pos-1] == NewLineChar.Byte() {
+			if s.scanLineStart() {
+				continue
+			}
+		}
+		switch s.top() {
+		case EntityPre:
+			s.scanPre()
+		case EntityCode:
+			s.scanCode()
+		default:
+			s.scanText()
+		}
+	}
+	for len(s.stack) > 0 {
+		s.close(0)
+	}
+}
+
+// scanLineStart handles the quote markers allowed at the start of a line.
+func (s *mdv2Scanner) scanLineStart() bool {
+	if top := s.top(); top == EntityPre || top == EntityCode {
+		return false
+	}
+	switch {
+	case s.hasPrefix("**>") && !s.inQuote():
+		s.open(EntityExpandableBlockquote, 3, HiddenTg.Bytes())
+		return true
+	case s.hasPrefix(">") && s.inQuote():
+		s.emit(mdv2Token{kind: mdv2QuoteMark, start: s.pos, end: s.pos + 1})
+		s.pos++
+		return true
+	case s.hasPrefix(">"):
+		s.open(EntityBlockquote, 1, nil)
+		return true
+	}
+	return false
+}
+
+func (s *mdv2Scanner) scanPre() {
+	switch {
+	case s.hasPrefix("```"):
+		// The line break before the closing fence belongs to the markup.
+		if last := &s.tokens[len(s.tokens)-1]; last.kind == mdv2Text && s.text[last.start] == NewLineChar.Byte() {
+			last.width = 0
+		}
+		s.close(3)
+	case s.text[s.pos] == SlashChar.Byte():
+		s.char(1)
+	default:
+		s.char(0)
+	}
+}
+
+func (s *mdv2Scanner) scanCode() {
+	switch s.text[s.pos] {
+	case BackqouteChar.Byte():
+		s.close(1)
+	case SlashChar.Byte():
+		s.char(1)
+	default:
+		s.char(0)
+	}
+}
+
+func (s *mdv2Scanner) scanText() {
+	c := s.text[s.pos]
+	switch {
+	case c == SlashChar.Byte():
+		s.char(1)
+	case c == '\r':
+		s.emit(mdv2Token{kind: mdv2Text, start: s.pos, end: s.pos + 1})
+		s.pos++
+	case c == AsteriskChar.Byte():
+		s.toggle(EntityBold, 1)
+	case s.hasPrefix("__"):
+		s.toggle(EntityUnderline, 2)
+	case c == UnderscoreChar.Byte():
+		s.toggle(EntityItalic, 1)
+	case c == TildeChar.Byte():
+		s.toggle(EntityStrikethrough, 1)
+	case s.hasPrefix("||"):
+		s.scanPipes()
+	case s.hasPrefix("```"):
+		end := bytes.IndexByte(s.text[s.pos:], NewLineChar.Byte())
+		if end < 0 {
+			end = 2
+		}
+		s.open(EntityPre, end+1, CodeTg.Bytes())
+	case c == BackqouteChar.Byte():
+		s.open(EntityCode, 1, SpanTg.Bytes())
+	case c == OpenBracketChar.Byte():
+		s.scanLinkStart()
+	case s.hasPrefix("!["):
+		s.scanCustomEmojiStart()
+	case c == CloseBracketChar.Byte() && (s.top() == EntityTextLink || s.top() == EntityCustomEmoji):
+		s.close(len(s.stack[len(s.stack)-1].close))
+	case c == NewLineChar.Byte():
+		s.scanNewLine()
+	default:
+		s.char(0)
+	}
+}
+
+// scanPipes handles "||", which closes a spoiler, ends an expandable quote
+// at the end of a line or opens a spoiler.
+func (s *mdv2Scanner) scanPipes() {
+	end := s.pos + 2
+	atLineEnd := end == len(s.text) || s.text[end] == NewLineChar.Byte()
+	switch {
+	case s.top() == EntitySpoiler:
+		s.close(2)
+	case s.top() == EntityExpandableBlockquote && atLineEnd:
+		s.close(2)
+	default:
+		s.open(EntitySpoiler, 2, HiddenTg.Bytes())
+	}
+}
+
+// scanLinkStart opens a text link if the matching "](url)" can be found.
+func (s *mdv2Scanner) scanLinkStart() {
+	closer := findLinkCloser(s.text[s.pos+1:])
+	if closer == nil {
+		s.char(0)
+		return
+	}
+	s.open(EntityTextLink, 1, closer)
+}
+
+// scanCustomEmojiStart opens a custom emoji if the matching
+// "](tg://emoji?id=...)" can be found. Otherwise '!' is text.
+func (s *mdv2Scanner) scanCustomEmojiStart() {
+	closer := findLinkCloser(s.text[s.pos+2:])
+	if closer == nil {
+		s.char(0)
+		return
+	}
+	if _, ok := customEmojiID(unescapeMarkdownV2(closer[2 : len(closer)-1])); !ok {
+		s.char(0)
+		return
+	}
+	s.open(EntityCustomEmoji, 2, closer)
+}
+
+// findLinkCloser returns the "](url)" part of a link whose text starts at
+// the beginning of text, or nil if there is none. Links nested in the text
+// are skipped.
+func findLinkCloser(text []byte) []byte {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case SlashChar.Byte():
+			i++
+		case NewLineChar.Byte():
+			return nil
+		case OpenBracketChar.Byte():
+			depth++
+		case CloseBracketChar.Byte():
+			end := linkURLEnd(text, i+1)
+			switch {
+			case end < 0:
+				return nil
+			case depth == 0:
+				return text[i:end]
+			}
+			depth--
+			i = end - 1
+		}
+	}
+	return nil
+}
+
+// linkURLEnd returns the end of the "(url)" at text[start:], or -1.
+func linkURLEnd(text []byte, start int) int {
+	if start >= len(text) || text[start] != OpenParenChar.Byte() {
+		return -1
+	}
+	for j := start + 1; j < len(text); j++ {
+		switch text[j] {
+		case SlashChar.Byte():
+			j++
+		case CloseParenChar.Byte():
+			return j + 1
+		}
+	}
+	return -1
+}
+
+// scanNewLine ends a quote whose next line does not continue it.
+func (s *mdv2Scanner) scanNewLine() {
+	next := s.text[s.pos+1:]
+	if s.inQuote() && !bytes.HasPrefix(next, []byte{GreaterThanChar.Byte()}) {
+		for s.inQuote() {
+			s.close(0)
+		}
+	}
+	s.char(0)
+}
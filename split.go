@@ -0,0 +1,282 @@
+package tgmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/yuin/goldmark/renderer"
+	textm "github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Telegram message size limits, measured in UTF-16 code units of the text
+// left after entity parsing.
+const (
+	MessageLimit = 4096
+	CaptionLimit = 1024
+)
+
+// split point preference, from worst to best.
+const (
+	cutNever = iota - 1
+	cutAnywhere
+	cutSpace
+	cutNewLine
+	cutBlock
+)
+
+// Split converts source to Telegram MarkdownV2 and splits the result into
+// messages whose visible text does not exceed limit. Splits are made at
+// block boundaries when possible, then at line breaks, then at spaces.
+// Entities that are open at a split point are closed at the end of a chunk
+// and reopened at the start of the next one, so every chunk is valid on
+// its own.
+func Split(source []byte, limit int, opts ...Option) ([][]byte, error) {
+	if limit < 2 {
+		return nil, fmt.Errorf("tgmd: split limit %d is too small", limit)
+	}
+	opts, _, err := withFrontMatter(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	output, err := convert(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	boundaries, err := blockBoundaries(source, opts...)
+	if err != nil {
+		return nil, err
+	}
+	chunks, _ := splitMarkdownV2(output, limit, boundaries)
+	return chunks, nil
+}
+
+// Chunk is a message of a document split by SplitResult.
+type Chunk struct {
+	// Text is the MarkdownV2 message.
+	Text []byte
+	// Plain and Entities hold the same message as text with entities, cut
+	// from ConvertEntities of the document. They are empty when its text
+	// does not match the MarkdownV2 messages.
+	Plain    string
+	Entities []Entity
+}
+
+// SplitResult converts source like ConvertResult and splits the message
+// like Split. Result.Text holds the whole message.
+func SplitResult(source []byte, limit int, opts ...Option) (*Result, []Chunk, error) {
+	if limit < 2 {
+		return nil, nil, fmt.Errorf("tgmd: split limit %d is too small", limit)
+	}
+	opts, fm, err := withFrontMatter(source, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := convertResult(source, opts, fm)
+	if err != nil {
+		return nil, nil, err
+	}
+	boundaries, err := blockBoundaries(source, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, entities, err := convertEntities(source, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	texts, spans := splitMarkdownV2(result.Text, limit, boundaries)
+	whole := utf16Len([]byte(plain)) == visibleWidth(result.Text)
+	chunks := make([]Chunk, len(texts))
+	for i, text := range texts {
+		chunks[i].Text = text
+		if whole {
+			chunks[i].Plain, chunks[i].Entities = cutEntities(plain, entities, spans[i])
+		}
+	}
+	return result, chunks, nil
+}
+
+// visibleWidth returns the length of a MarkdownV2 message after entity
+// parsing, in UTF-16 code units.
+func visibleWidth(text []byte) int {
+	width := 0
+	for _, t := range scanMarkdownV2(text) {
+		width += t.width
+	}
+	return width
+}
+
+// span is a range of visible text, in UTF-16 code units.
+type span struct {
+	start, end int
+}
+
+// cutEntities returns the part of text within s, and the parts of entities
+// over it.
+func cutEntities(text string, entities []Entity, s span) (string, []Entity) {
+	var (
+		b   strings.Builder
+		pos int
+	)
+	for _, r := range text {
+		if pos >= s.start && pos < s.end {
+			b.WriteRune(r)
+		}
+		pos += utf16.RuneLen(r)
+	}
+	var cut []Entity
+	for _, e := range entities {
+		start, end := max(e.Offset, s.start), min(e.Offset+e.Length, s.end)
+		if start >= end {
+			continue
+		}
+		e.Offset, e.Length = start-s.start, end-start
+		cut = append(cut, e)
+	}
+	return b.String(), cut
+}
+
+// blockBoundaries returns the indexes of the output lines that end a
+// top-level block, except for the last one.
+func blockBoundaries(source []byte, opts ...Option) (map[int]bool, error) {
+	cfg := newConfig(opts...)
+	r := renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(newTgmdNodeRenderer(cfg), 1000),
+		),
+	)
+	doc := TGMD(opts...).Parser().Parse(textm.NewReader(source))
+
+	var buf bytes.Buffer
+	boundaries := map[int]bool{}
+	for c := doc.FirstChild(); c != nil; c = c.NextSibling() {
+		if buf.Len() > 0 {
+			boundaries[bytes.Count(buf.Bytes(), []byte{NewLineChar.Byte()})] = true
+		}
+		if err := r.Render(&buf, source, c); err != nil {
+			return nil, err
+		}
+	}
+	return boundaries, nil
+}
+
+// splitMarkdownV2 splits a MarkdownV2 message into chunks of at most limit
+// visible characters, and returns the visible text of the message each
+// chunk holds. boundaries holds the indexes of lines that end a block and
+// are preferred as split points.
+func splitMarkdownV2(text []byte, limit int, boundaries map[int]bool) ([][]byte, []span) {
+	tokens := scanMarkdownV2(text)
+	offsets := make([]int, len(tokens)+1)
+	for i, t := range tokens {
+		offsets[i+1] = offsets[i] + t.width
+	}
+
+	// rank every position a chunk may end at, i.e. before tokens[i].
+	ranks := make([]int, len(tokens)+1)
+	line := 0
+	for i, t := range tokens {
+		switch {
+		case i > 0 && (t.kind == mdv2Close || tokens[i-1].kind == mdv2Open):
+			// Never leave an empty entity behind.
+			ranks[i] = cutNever
+		case t.isNewLine(text):
+			ranks[i] = cutNewLine
+			if boundaries[line] {
+				ranks[i] = cutBlock
+			}
+		case t.isSpace(text):
+			ranks[i] = cutSpace
+		}
+		line += bytes.Count(text[t.start:t.end], []byte{NewLineChar.Byte()})
+	}
+
+	var (
+		chunks [][]byte
+		spans  []span
+		stack  []mdv2Token
+		start  int
+	)
+	for start < len(tokens) {
+		// Drop the space or line breaks the previous chunk was cut at, along
+		// with quote markers that get reopened below.
+		if start > 0 && ranks[start] == cutSpace {
+			start++
+		}
+		for start < len(tokens) && (tokens[start].isNewLine(text) || tokens[start].kind == mdv2QuoteMark) {
+			start++
+		}
+		if start == len(tokens) {
+			break
+		}
+
+		end, width := start, 0
+		best := [cutBlock + 1]int{}
+		bestWidth := [cutBlock + 1]int{}
+		for end < len(tokens) && (end == start || width+tokens[end].width <= limit) {
+			if end > start && ranks[end] != cutNever {
+				best[ranks[end]], bestWidth[ranks[end]] = end, width
+			}
+			width += tokens[end].width
+			end++
+		}
+		if end < len(tokens) {
+			if ranks[end] != cutNever {
+				best[ranks[end]], bestWidth[ranks[end]] = end, width
+			}
+			end = pickCut(best, bestWidth, limit, end)
+		}
+
+		var chunk bytes.Buffer
+		for _, t := range stack {
+			chunk.Write(t.open)
+		}
+		stack = applyTokens(stack, tokens[start:end])
+		chunk.Write(text[tokens[start].start:tokens[end-1].end])
+		for i := len(stack) - 1; i >= 0; i-- {
+			chunk.Write(stack[i].close)
+		}
+		chunks = append(chunks, chunk.Bytes())
+		// The chunk may end with a line break closing a reopened pre block.
+		spans = append(spans, span{offsets[start], offsets[start] + visibleWidth(chunk.Bytes())})
+		start = end
+	}
+	return chunks, spans
+}
+
+// pickCut chooses the best position to end a chunk at. Better ranked split
+// points win unless they would leave the chunk less than half full.
+func pickCut(best, bestWidth [cutBlock + 1]int, limit, fallback int) int {
+	latest := 0
+	for rank := cutBlock; rank >= cutAnywhere; rank-- {
+		if best[rank] == 0 {
+			continue
+		}
+		if bestWidth[rank]*2 >= limit {
+			return best[rank]
+		}
+		latest = max(latest, best[rank])
+	}
+	if latest == 0 {
+		return fallback
+	}
+	return latest
+}
+
+// applyTokens returns the stack of open entities after tokens.
+func applyTokens(stack []mdv2Token, tokens []mdv2Token) []mdv2Token {
+	stack = append([]mdv2Token(nil), stack...)
+	for _, t := range tokens {
+		switch t.kind {
+		case mdv2Open:
+			stack = append(stack, t)
+		case mdv2Close:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return stack
+}
@@ -0,0 +1,137 @@
+package tgmd
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit_ReopensEntities(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		limit    int
+		opts     []Option
+		expected []string
+	}{
+		{
+			name:     "Fits in One Message",
+			input:    "**short**",
+			limit:    MessageLimit,
+			expected: []string{"*short*"},
+		},
+		{
+			name:     "Bold Split at Space",
+			input:    "**aaa bbb ccc**",
+			limit:    8,
+			expected: []string{"*aaa bbb*", "*ccc*"},
+		},
+		{
+			name:     "Escapes Kept Whole",
+			input:    "a.b.c.d.e.f",
+			limit:    5,
+			expected: []string{"a\\.b\\.c", "\\.d\\.e\\.", "f"},
+		},
+		{
+			name:     "Prefer Block Boundaries",
+			input:    "First para.\n\nSecond para.",
+			limit:    20,
+			expected: []string{"First para\\.", "Second para\\.\n"},
+		},
+		{
+			name:     "Code Fence Reopened",
+			input:    "```go\nline one\nline two\n```",
+			limit:    12,
+			expected: []string{"```go\nline one```", "```go\nline two\n```"},
+		},
+		{
+			name:     "Expandable Quote Reopened",
+			input:    "Line 1\nLine 2",
+			limit:    7,
+			opts:     []Option{WithQuote(QuoteConfig{Enable: true, Expandable: true})},
+			expected: []string{"**>Line 1||", "**>Line 2||"},
+		},
+		{
+			name:     "Front Matter",
+			input:    "---\nquote:\n  enable: true\n---\nFirst para.\n\n---\n\nLast.",
+			limit:    16,
+			opts:     []Option{WithFrontMatter()},
+			expected: []string{">First para\\.", ">──────────", ">Last\\."},
+		},
+		{
+			name:     "Link Reopened",
+			input:    "[one two](https://example.com/a_b)",
+			limit:    4,
+			expected: []string{"[one](https://example.com/a_b)", "[two](https://example.com/a_b)"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks, err := Split([]byte(tc.input), tc.limit, tc.opts...)
+			if err != nil {
+				t.Fatalf("Split failed: %v", err)
+			}
+			got := make([]string, len(chunks))
+			for i, c := range chunks {
+				got[i] = string(c)
+			}
+			if strings.Join(got, "\x00") != strings.Join(tc.expected, "\x00") {
+				t.Errorf("Output mismatch:\nExpected: %q\nGot:      %q", tc.expected, got)
+			}
+			for i, c := range chunks {
+				if err := Validate(c); err != nil {
+					t.Errorf("Chunk %d is not valid: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSplit_ExampleWithinLimit(t *testing.T) {
+	source, err := os.ReadFile("example/source.md")
+	if err != nil {
+		t.Fatalf("Failed to read source.md: %v", err)
+	}
+	for _, limit := range []int{40, 100, 300} {
+		chunks, err := Split(source, limit)
+		if err != nil {
+			t.Fatalf("Split failed: %v", err)
+		}
+		if len(chunks) < 2 {
+			t.Errorf("limit %d: expected several chunks, got %d", limit, len(chunks))
+		}
+		for i, c := range chunks {
+			if w := visibleWidth(c); w > limit {
+				t.Errorf("limit %d: chunk %d is %d characters long: %q", limit, i, w, c)
+			}
+			if err := Validate(c); err != nil {
+				t.Errorf("limit %d: chunk %d is not valid: %v\n%q", limit, i, err, c)
+			}
+		}
+	}
+}
+
+func TestSplitResult_ChunkEntities(t *testing.T) {
+	source, err := os.ReadFile("example/source.md")
+	if err != nil {
+		t.Fatalf("Failed to read source.md: %v", err)
+	}
+	for _, limit := range []int{40, 100, 300} {
+		_, chunks, err := SplitResult(source, limit)
+		if err != nil {
+			t.Fatalf("SplitResult failed: %v", err)
+		}
+		for i, c := range chunks {
+			text, entities, err := ParseMarkdownV2(c.Text)
+			if err != nil {
+				t.Fatalf("limit %d: chunk %d: %v", limit, i, err)
+			}
+			if c.Plain != text || !reflect.DeepEqual(c.Entities, entities) {
+				t.Errorf("limit %d: chunk %d mismatch:\nExpected: %q %+v\nGot:      %q %+v",
+					limit, i, text, entities, c.Plain, c.Entities)
+			}
+		}
+	}
+}
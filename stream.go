@@ -0,0 +1,489 @@
+package tgmd
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	textm "github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Stream converts a Markdown document that arrives in pieces, such as the
+// tokens of an LLM answer, and renders a valid MarkdownV2 snapshot of what
+// has arrived so far at any time.
+//
+// Snapshots close emphasis, spoilers, underline, strikethrough and code
+// spans left open at the end of the text; an unterminated code fence runs
+// to the end of the snapshot. Top-level blocks that are followed by a
+// blank line and another block are final, so they are rendered once and
+// reused by later snapshots.
+//
+// With WithFrontMatter, snapshots stay empty while the front matter is
+// being written; its settings apply from the first block on.
+//
+// A Stream is not safe for concurrent use.
+type Stream struct {
+	opts     []Option
+	config   *config
+	parser   parser.Parser
+	renderer renderer.Renderer
+
+	source []byte
+	// resolved is set once the front matter, if any, has been applied, and
+	// body is the offset of the document after it.
+	resolved bool
+	body     int
+	// stable is the length of the prefix of source whose blocks are final.
+	// Its rendering is kept in stableOutput, trailing newlines removed, and
+	// the number of its top-level blocks in stableBlocks.
+	stable       int
+	stableOutput []byte
+	stableBlocks int
+	// noCache is set once the source defines link references, which may
+	// change blocks written before them.
+	noCache bool
+}
+
+// NewStream returns a Stream rendering with opts.
+func NewStream(opts ...Option) *Stream {
+	s := &Stream{opts: opts}
+	s.configure(opts)
+	return s
+}
+
+// configure sets the options s renders with. The blocks are rendered
+// without the hashtags footer, which render writes once at the end, and
+// without front matter, which resolveFrontMatter skips.
+func (s *Stream) configure(opts []Option) {
+	s.config = newConfig(opts...)
+	blocks := newConfig(opts...)
+	blocks.hashtags, blocks.frontMatter = nil, false
+	s.parser = goldmark.New(goldmark.WithExtensions(extensions(blocks)...)).Parser()
+	s.renderer = renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(newTgmdNodeRenderer(blocks), 1000),
+		),
+	)
+}
+
+// Write appends p to the document. It never fails.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.source = append(s.source, p...)
+	return len(p), nil
+}
+
+// Snapshot renders the document written so far, closing the markup left
+// open at its end.
+func (s *Stream) Snapshot() ([]byte, error) {
+	return s.render()
+}
+
+// Final converts the whole document written so far with Convert, leaving
+// unmatched markup as text. Call it once the document is complete.
+func (s *Stream) Final() ([]byte, error) {
+	return Convert(s.source, s.opts...)
+}
+
+// linkReferenceDefinition matches the start of a link reference definition.
+var linkReferenceDefinition = regexp.MustCompile(`(?m)^[ >]*\[(?:[^\]\\]|\\.)+\]:`)
+
+func (s *Stream) render() ([]byte, error) {
+	if ready, err := s.resolveFrontMatter(); !ready || err != nil {
+		return nil, err
+	}
+	if !s.noCache && linkReferenceDefinition.Match(s.source[s.stable:]) {
+		s.noCache = true
+		s.stable, s.stableOutput, s.stableBlocks = s.body, nil, 0
+	}
+	if !s.noCache {
+		if err := s.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	tail := closeMarkdown(completeRunes(s.source[s.stable:]), s.config.underlineSyntax)
+	output, blocks, err := s.renderBlocks(tail)
+	if err != nil {
+		return nil, err
+	}
+
+	result := append([]byte(nil), s.stableOutput...)
+	if len(result) > 0 && len(output) > 0 {
+		result = append(result, NewLineChar.Bytes(2)...)
+	}
+	result = append(result, output...)
+	footer := s.config.hashtagLine()
+	if footer != nil {
+		if len(result) > 0 {
+			result = append(result, NewLineChar.Bytes(2)...)
+		}
+		result = append(result, escapeBytes(footer, escape)...)
+	}
+	if blocks += s.stableBlocks; blocks > 1 || blocks > 0 && footer != nil {
+		// Convert ends documents of several blocks with a newline.
+		result = append(result, NewLineChar.Byte())
+	}
+	if s.config.Quote.Enable {
+		return quoteLines(result, s.config.Quote.Expandable), nil
+	}
+	return result, nil
+}
+
+// resolveFrontMatter applies the front matter once it is complete and
+// moves the start of the document past it. It reports whether the document
+// can be rendered, which it cannot while the front matter is incomplete.
+func (s *Stream) resolveFrontMatter() (bool, error) {
+	if s.resolved || !s.config.frontMatter {
+		return true, nil
+	}
+	first, _, complete := bytes.Cut(s.source, NewLineChar.Bytes(1))
+	delimiter := string(bytes.TrimRight(first, " \t\r"))
+	if delimiter != "" && (strings.HasPrefix("---", delimiter) || strings.HasPrefix("+++", delimiter)) {
+		if !complete {
+			return false, nil
+		}
+		if _, end, ok := frontMatterBlock(s.source); ok {
+			opts, _, err := withFrontMatter(s.source, s.opts)
+			if err != nil {
+				return false, err
+			}
+			s.configure(opts)
+			s.body, s.stable = end, end
+		} else if delimiter == "---" || delimiter == "+++" {
+			return false, nil
+		}
+	}
+	s.resolved = true
+	return true, nil
+}
+
+// advance moves the blocks of the tail that are final into the stable
+// prefix. The last top-level block is final once a blank line and the
+// complete first line of another top-level block follow it.
+func (s *Stream) advance() error {
+	tail := s.source[s.stable:]
+	doc := s.parser.Parse(textm.NewReader(tail))
+	cut := -1
+	for c := doc.LastChild(); c != nil && c != doc.FirstChild(); c = c.PreviousSibling() {
+		start := lineStart(tail, blockStart(c))
+		if start <= 0 || !c.HasBlankPreviousLines() || !followsBlankLine(tail, start) ||
+			tail[start] == SpaceChar.Byte() || bytes.IndexByte(tail[start:], NewLineChar.Byte()) < 0 {
+			continue
+		}
+		cut = start
+		break
+	}
+	if cut < 0 {
+		return nil
+	}
+
+	output, blocks, err := s.renderBlocks(tail[:cut])
+	if err != nil {
+		return err
+	}
+	if len(s.stableOutput) > 0 && len(output) > 0 {
+		s.stableOutput = append(s.stableOutput, NewLineChar.Bytes(2)...)
+	}
+	s.stableOutput = append(s.stableOutput, output...)
+	s.stableBlocks += blocks
+	s.stable += cut
+	return nil
+}
+
+// renderBlocks renders source as a document without its trailing newlines
+// and returns the number of its top-level blocks.
+func (s *Stream) renderBlocks(source []byte) ([]byte, int, error) {
+	doc := s.parser.Parse(textm.NewReader(source))
+	var buf bytes.Buffer
+	if err := s.renderer.Render(&buf, source, doc); err != nil {
+		return nil, 0, err
+	}
+	blocks := 0
+	for c := doc.FirstChild(); c != nil; c = c.NextSibling() {
+		blocks++
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), blocks, nil
+}
+
+// completeRunes drops an incomplete UTF-8 sequence at the end of b, left
+// by a write that split a character.
+func completeRunes(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// blockStart returns the source offset of the first line of block n, or
+// -1 when it has none.
+func blockStart(n ast.Node) int {
+	if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+		return n.Lines().At(0).Start
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok {
+			return t.Segment.Start
+		}
+		if start := blockStart(c); start >= 0 {
+			return start
+		}
+	}
+	return -1
+}
+
+// followsBlankLine reports whether the line starting at source[start] comes
+// after a blank line. Block starts are taken from their first line of
+// content, which for code blocks is not where the block begins.
+func followsBlankLine(source []byte, start int) bool {
+	if start < 2 {
+		return false
+	}
+	prev := source[lineStart(source, start-1) : start-1]
+	return len(bytes.TrimSpace(prev)) == 0
+}
+
+// lineStart returns the start of the line holding source[offset], or -1.
+func lineStart(source []byte, offset int) int {
+	if offset < 0 {
+		return -1
+	}
+	return bytes.LastIndexByte(source[:offset], NewLineChar.Byte()) + 1
+}
+
+// closeMarkdown appends the delimiters closing the emphasis, spoilers,
+// underline, strikethrough and code spans left open in the last paragraph
+// of source. Openers with nothing after them are removed instead.
+func closeMarkdown(source []byte, syntax UnderlineSyntax) []byte {
+	start, fence := 0, []byte(nil)
+	for pos := 0; pos < len(source); {
+		end := bytes.IndexByte(source[pos:], NewLineChar.Byte())
+		if end < 0 {
+			end = len(source)
+		} else {
+			end += pos
+		}
+		line := source[pos:end]
+		switch {
+		case fence != nil:
+			if closesFence(line, fence) {
+				fence = nil
+				start = end
+			}
+		case openingFence(line) != nil:
+			fence = openingFence(line)
+		case len(bytes.TrimSpace(line)) == 0:
+			start = end
+		}
+		pos = end + 1
+	}
+	if fence != nil {
+		// goldmark ends an unterminated fence with the document.
+		return source
+	}
+	closed := closeInline(source[start:], syntax)
+	return append(source[:start:start], closed...)
+}
+
+// openingFence returns the fence that opens a code block on line, or nil.
+func openingFence(line []byte) []byte {
+	trimmed := bytes.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) == 0 ||
+		(trimmed[0] != BackqouteChar.Byte() && trimmed[0] != TildeChar.Byte()) {
+		return nil
+	}
+	n := runLength(trimmed, 0)
+	if n < 3 || (trimmed[0] == BackqouteChar.Byte() && bytes.IndexByte(trimmed[n:], BackqouteChar.Byte()) >= 0) {
+		return nil
+	}
+	return trimmed[:n]
+}
+
+// closesFence reports whether line closes a code block opened by fence.
+func closesFence(line, fence []byte) bool {
+	trimmed := bytes.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) == 0 || trimmed[0] != fence[0] {
+		return false
+	}
+	n := runLength(trimmed, 0)
+	return n >= len(fence) && len(bytes.TrimSpace(trimmed[n:])) == 0
+}
+
+// runLength returns the number of times b[i] repeats from i.
+func runLength(b []byte, i int) int {
+	n := 0
+	for i+n < len(b) && b[i+n] == b[i] {
+		n++
+	}
+	return n
+}
+
+// openDelimiter is an emphasis-like delimiter waiting to be closed.
+type openDelimiter struct {
+	marker []byte
+	pos    int
+}
+
+// closeInline closes the inline markup left open at the end of a
+// paragraph, following the CommonMark flanking rules closely enough for
+// text that is still being written.
+func closeInline(text []byte, syntax UnderlineSyntax) []byte {
+	var (
+		stack   []openDelimiter
+		code    int
+		codePos int
+	)
+	for i := 0; i < len(text); {
+		if i == 0 || text[i-1] == NewLineChar.Byte() {
+			if startsBlock(text[i:]) {
+				stack, code = nil, 0
+			}
+		}
+		c := text[i]
+		if code > 0 {
+			n := 1
+			if c == BackqouteChar.Byte() {
+				n = runLength(text, i)
+				if n == code {
+					code = 0
+				}
+			}
+			i += n
+			continue
+		}
+		switch {
+		case c == SlashChar.Byte():
+			i += 2
+		case c == BackqouteChar.Byte():
+			code, codePos = runLength(text, i), i
+			i += code
+		case c == AsteriskChar.Byte() || c == UnderscoreChar.Byte() || c == TildeChar.Byte() ||
+			c == PipeChar.Byte() || (c == PlusChar.Byte() && syntax == UnderlinePlus):
+			n := runLength(text, i)
+			stack = matchDelimiters(stack, text, i, n)
+			i += n
+		default:
+			i++
+		}
+	}
+
+	end := len(bytes.TrimRight(text, " \t\n"))
+	var closers []byte
+	if code > 0 {
+		if codePos+code >= end {
+			end = len(bytes.TrimRight(text[:codePos], " \t\n"))
+		} else {
+			if text[end-1] == BackqouteChar.Byte() {
+				closers = append(closers, SpaceChar.Byte())
+			}
+			closers = append(closers, bytes.Repeat([]byte{BackqouteChar.Byte()}, code)...)
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		d := stack[i]
+		if d.pos+len(d.marker) >= end && len(closers) == 0 {
+			end = len(bytes.TrimRight(text[:d.pos], " \t\n"))
+			continue
+		}
+		closers = append(closers, d.marker...)
+	}
+	return append(text[:end:end], closers...)
+}
+
+// startsBlock reports whether line starts a list item, heading or quote,
+// which begin a new inline context.
+func startsBlock(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, " ")
+	switch {
+	case len(trimmed) == 0:
+		return false
+	case trimmed[0] == '#' || trimmed[0] == GreaterThanChar.Byte():
+		return true
+	case len(trimmed) > 1 && bytes.IndexByte([]byte("-*+"), trimmed[0]) >= 0 && trimmed[1] == SpaceChar.Byte():
+		return true
+	}
+	digits := 0
+	for digits < len(trimmed) && trimmed[digits] >= '0' && trimmed[digits] <= '9' {
+		digits++
+	}
+	return digits > 0 && digits+1 < len(trimmed) &&
+		(trimmed[digits] == '.' || trimmed[digits] == ')') && trimmed[digits+1] == SpaceChar.Byte()
+}
+
+// matchDelimiters handles the run of n delimiter characters at text[i],
+// closing the delimiters it matches on the stack and opening new ones.
+func matchDelimiters(stack []openDelimiter, text []byte, i, n int) []openDelimiter {
+	c := text[i]
+	before, after := rune(' '), rune(-1)
+	if i > 0 {
+		before, _ = utf8.DecodeLastRune(text[:i])
+	}
+	if i+n < len(text) {
+		after, _ = utf8.DecodeRune(text[i+n:])
+	}
+	canOpen, canClose := flanking(before, after)
+	if c == UnderscoreChar.Byte() {
+		canOpen, canClose = canOpen && (!canClose || util.IsPunctRune(before)),
+			canClose && (!canOpen || after < 0 || util.IsPunctRune(after))
+	}
+	pair := c == TildeChar.Byte() || c == PipeChar.Byte() || c == PlusChar.Byte()
+	if pair && n != 2 && !(c == TildeChar.Byte() && n == 1) {
+		return stack
+	}
+
+	remaining := n
+	for canClose && remaining > 0 {
+		j := len(stack) - 1
+		for j >= 0 && stack[j].marker[0] != c {
+			j--
+		}
+		if j < 0 || (pair && len(stack[j].marker) != remaining) {
+			break
+		}
+		// Delimiters opened inside the matched one stay text.
+		stack = stack[:j+1]
+		if m := len(stack[j].marker); remaining >= m {
+			remaining -= m
+			stack = stack[:j]
+		} else {
+			stack[j].marker = stack[j].marker[:m-remaining]
+			remaining = 0
+		}
+	}
+	if remaining == n && after < 0 {
+		// A run ending the text may be an opener still being written.
+		canOpen = true
+	}
+	if canOpen && remaining > 0 && remaining <= 3 {
+		pos := i + n - remaining
+		if remaining >= 2 {
+			stack = append(stack, openDelimiter{marker: text[pos : pos+2], pos: pos})
+			pos += 2
+			remaining -= 2
+		}
+		if remaining == 1 {
+			stack = append(stack, openDelimiter{marker: text[pos : pos+1], pos: pos})
+		}
+	}
+	return stack
+}
+
+// flanking reports whether a delimiter run between before and after is
+// left-flanking and right-flanking. after is negative at the end of text.
+func flanking(before, after rune) (left, right bool) {
+	isSpace := func(r rune) bool { return r < 0 || unicode.IsSpace(r) }
+	left = !isSpace(after) && (!util.IsPunctRune(after) || isSpace(before) || util.IsPunctRune(before))
+	right = !isSpace(before) && (!util.IsPunctRune(before) || isSpace(after) || util.IsPunctRune(after))
+	return left, right
+}
@@ -0,0 +1,165 @@
+package tgmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStream_Snapshot(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		opts     []Option
+		expected string
+	}{
+		{name: "Open Bold", input: "Hello **wor", expected: "Hello *wor*"},
+		{name: "Open Italic After Bold", input: "**bold** and _it", expected: "*bold* and _it_"},
+		{name: "Nested", input: "**bold ~~strike", expected: "*bold ~strike~*"},
+		{name: "Spoiler", input: "||secret", expected: "||secret||"},
+		{name: "Underline", input: "++under", expected: "__under__"},
+		{name: "Code Span", input: "run `go test", expected: "run `go test`"},
+		{name: "Markup Inside Code Span", input: "`a **b", expected: "`a **b`"},
+		{name: "Dangling Opener Dropped", input: "Hello **", expected: "Hello"},
+		{name: "Dangling Opener After Text Dropped", input: "**bold** _", expected: "*bold*"},
+		{name: "Not An Opener", input: "2 * 3", expected: "2 \\* 3"},
+		{name: "Intraword Underscore", input: "snake_case", expected: "snake\\_case"},
+		{name: "Closed Paragraph Left Alone", input: "a **b\n\nc **d", expected: "a \\*\\*b\n\nc *d*\n"},
+		{name: "Open Fence", input: "```go\nfmt.Println(`hi", expected: "```go\nfmt.Println(\\`hi\n```"},
+		{name: "List Item", input: "- one\n- two **bo", expected: "  • one\n  • two *bo*"},
+		{name: "Split Rune", input: "smile \xf0\x9f\x98", expected: "smile"},
+		{
+			name:     "Open Front Matter",
+			input:    "---\nquote:\n  enable: true\n",
+			opts:     []Option{WithFrontMatter()},
+			expected: "",
+		},
+		{
+			name:     "Quoted",
+			input:    "Line 1\n\n**Line",
+			opts:     []Option{WithQuote(QuoteConfig{Enable: true, Expandable: true})},
+			expected: "**>Line 1\n>\n>*Line*||",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewStream(tc.opts...)
+			_, _ = s.Write([]byte(tc.input))
+			got, err := s.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+			if string(got) != tc.expected {
+				t.Errorf("Snapshot mismatch:\nExpected: %q\nGot:      %q", tc.expected, got)
+			}
+			if err := Validate(got); err != nil {
+				t.Errorf("Snapshot is not valid: %v", err)
+			}
+		})
+	}
+}
+
+func TestStream_ChunkedExample(t *testing.T) {
+	source, err := os.ReadFile("example/source.md")
+	if err != nil {
+		t.Fatalf("Failed to read source.md: %v", err)
+	}
+	for _, opts := range [][]Option{
+		nil,
+		{WithQuote(QuoteConfig{Enable: true, Expandable: true})},
+		{WithTableStyle(TableList), WithHTMLPolicy(HTMLTranslate)},
+	} {
+		expected, err := Convert(source, opts...)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+
+		s := NewStream(opts...)
+		for start := 0; start < len(source); start += 7 {
+			_, _ = s.Write(source[start:min(start+7, len(source))])
+			snapshot, err := s.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+			if err := Validate(snapshot); err != nil {
+				t.Fatalf("Snapshot after %d bytes is not valid: %v\n%q", start+7, err, snapshot)
+			}
+		}
+		if s.stable == 0 {
+			t.Error("Expected finished blocks to be cached")
+		}
+
+		got, err := s.Final()
+		if err != nil {
+			t.Fatalf("Final failed: %v", err)
+		}
+		if string(got) != string(expected) {
+			t.Errorf("Final mismatch:\nExpected: %q\nGot:      %q", expected, got)
+		}
+	}
+}
+
+func TestStream_LinkReferenceDisablesCache(t *testing.T) {
+	s := NewStream()
+	_, _ = s.Write([]byte("See [docs].\n\nMore text.\n\nEven more.\n"))
+	if _, err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	_, _ = s.Write([]byte("\n[docs]: https://example.com\n"))
+	got, err := s.Final()
+	if err != nil {
+		t.Fatalf("Final failed: %v", err)
+	}
+	expected := "See [docs](https://example.com)\\.\n\nMore text\\.\n\nEven more\\.\n"
+	if string(got) != expected {
+		t.Errorf("Final mismatch:\nExpected: %q\nGot:      %q", expected, got)
+	}
+}
+
+func TestStream_SnapshotMatchesConvert(t *testing.T) {
+	testCases := []struct {
+		name   string
+		source string
+		opts   []Option
+	}{
+		{
+			name:   "Hashtags",
+			source: "# Title\n\nFirst **block**.\n\nSecond block.\n\n- item\n",
+			opts:   []Option{WithHashtags("release", "v2")},
+		},
+		{
+			name:   "Front Matter",
+			source: "---\nhashtags: [news]\nquote:\n  enable: true\n---\n# Title\n\nFirst block.\n\n---\n\nLast block.\n",
+			opts:   []Option{WithFrontMatter()},
+		},
+		{
+			name:   "Without Front Matter",
+			source: "--- not a delimiter\n\nText.\n\n---\nfoo\n---\n",
+			opts:   []Option{WithFrontMatter()},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, err := Convert([]byte(tc.source), tc.opts...)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+
+			s := NewStream(tc.opts...)
+			var snapshot []byte
+			for start := 0; start < len(tc.source); start += 5 {
+				_, _ = s.Write([]byte(tc.source[start:min(start+5, len(tc.source))]))
+				if snapshot, err = s.Snapshot(); err != nil {
+					t.Fatalf("Snapshot failed: %v", err)
+				}
+				if err := Validate(snapshot); err != nil {
+					t.Fatalf("Snapshot after %d bytes is not valid: %v\n%q", start+5, err, snapshot)
+				}
+			}
+			if string(snapshot) != string(expected) {
+				t.Errorf("Snapshot mismatch:\nExpected: %q\nGot:      %q", expected, snapshot)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package tgmd
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
+)
+
+// TableStyle defines how GFM tables are rendered.
+type TableStyle int
+
+// define table styles.
+const (
+	// TableMonospace renders tables as aligned columns inside a pre block.
+	TableMonospace TableStyle = iota
+	// TableList renders every row as a bulleted list of "header: value"
+	// lines, which reads better on narrow mobile screens.
+	TableList
+)
+
+type table struct{}
+
+// Tables parses GFM tables.
+var Tables = &table{}
+
+// Extend ...
+func (e *table) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithParagraphTransformers(
+			util.Prioritized(ext.NewTableParagraphTransformer(), 200),
+		),
+		parser.WithASTTransformers(
+			util.Prioritized(ext.NewTableASTTransformer(), 0),
+		),
+	)
+}
+
+// tableCells returns the plain text of every cell of n, header row first.
+func tableCells(source []byte, n *extast.Table) [][]string {
+	var rows [][]string
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, string(plainText(source, cell)))
+		}
+		rows = append(rows, cells)
+	}
+	return rows
+}
+
+// tableMonospace lays n out as lines of aligned columns, measuring cells by
+// their display width.
+func tableMonospace(source []byte, n *extast.Table) string {
+	rows := tableCells(source, n)
+	widths := make([]int, len(n.Alignments))
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], displayWidth(cell))
+			}
+		}
+	}
+
+	var b strings.Builder
+	for r, row := range rows {
+		if r > 0 {
+			b.WriteByte(NewLineChar.Byte())
+		}
+		for i, width := range widths {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			pad := width - displayWidth(cell)
+			left := 0
+			switch n.Alignments[i] {
+			case extast.AlignRight:
+				left = pad
+			case extast.AlignCenter:
+				left = pad / 2
+			}
+			b.WriteString(strings.Repeat(" ", left))
+			b.WriteString(cell)
+			if i < len(widths)-1 {
+				b.WriteString(strings.Repeat(" ", pad-left))
+			}
+		}
+		if r == 0 {
+			b.WriteByte(NewLineChar.Byte())
+			for i, width := range widths {
+				if i > 0 {
+					b.WriteString("-|-")
+				}
+				b.WriteString(strings.Repeat("-", width))
+			}
+		}
+	}
+	return b.String()
+}
+
+// tableField is a "header: value" pair of the list table style.
+type tableField struct {
+	key   string
+	value string
+}
+
+// tableRecords returns the body rows of n as lists of "header: value"
+// pairs. Columns with an empty header are reduced to their value.
+func tableRecords(source []byte, n *extast.Table) [][]tableField {
+	rows := tableCells(source, n)
+	if len(rows) == 0 {
+		return nil
+	}
+	header := rows[0]
+	records := make([][]tableField, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		fields := make([]tableField, 0, len(row))
+		for i, cell := range row {
+			key := ""
+			if i < len(header) {
+				key = header[i]
+			}
+			fields = append(fields, tableField{key: key, value: cell})
+		}
+		records = append(records, fields)
+	}
+	return records
+}
+
+// tableRecordPrefix returns the text written before field i of a record
+// in the list table style: the bullet for the first field, an indentation
+// for the others.
+func (c *config) tableRecordPrefix(i int) string {
+	if i == 0 {
+		return "  " + string(c.listBullet(0)) + " "
+	}
+	return "    "
+}
+
+// plainText returns the text of n and its descendants without formatting.
+func plainText(source []byte, n ast.Node) []byte {
+	var text []byte
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := c.(type) {
+		case *ast.Text:
+			text = append(text, textValue(source, t)...)
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				text = append(text, SpaceChar.Byte())
+			}
+		case *ast.String:
+			text = append(text, t.Value...)
+		case *ast.CodeSpan:
+			text = append(text, codeSpanContent(source, t)...)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return text
+}
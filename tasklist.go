@@ -0,0 +1,48 @@
+package tgmd
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
+)
+
+type taskList struct{}
+
+// TaskList parses GFM task list items ("- [ ] todo", "- [x] done").
+var TaskList = &taskList{}
+
+// Extend ...
+func (e *taskList) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		// must take precedence over the link parser
+		util.Prioritized(ext.NewTaskCheckBoxParser(), 0),
+	))
+}
+
+// taskCheckBox returns the check box of list item n, or nil if n is not a task.
+func taskCheckBox(n *ast.ListItem) *extast.TaskCheckBox {
+	if n.FirstChild() == nil {
+		return nil
+	}
+	box, _ := n.FirstChild().FirstChild().(*extast.TaskCheckBox)
+	return box
+}
+
+// replacesBullet reports whether check box n is written in place of the
+// bullet of its list item. Ordered lists keep their numbers and get the
+// check box after them.
+func replacesBullet(n *extast.TaskCheckBox) bool {
+	list, ok := n.Parent().Parent().Parent().(*ast.List)
+	return ok && !list.IsOrdered()
+}
+
+// taskGlyph returns the rune used to render a check box.
+func (c *config) taskGlyph(n *extast.TaskCheckBox) rune {
+	if n.IsChecked {
+		return c.taskCheckBoxes[1]
+	}
+	return c.taskCheckBoxes[0]
+}
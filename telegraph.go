@@ -0,0 +1,580 @@
+package tgmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	ext "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// TelegraphContentLimit is the largest content, in bytes of JSON, that
+// Telegraph accepts for a page.
+const TelegraphContentLimit = 64 * 1024
+
+// TelegraphNode is a Telegraph API Node: a text node when Tag is empty, an
+// element otherwise.
+type TelegraphNode struct {
+	// Text is the content of a text node.
+	Text string
+	// Tag is the name of an element, one of the tags Telegraph allows.
+	Tag string
+	// Href and Src are the attributes of a and img elements.
+	Href string
+	Src  string
+	// Children holds the content of an element.
+	Children []TelegraphNode
+}
+
+// telegraphElement is the JSON form of an element.
+type telegraphElement struct {
+	Tag      string            `json:"tag"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Children []TelegraphNode   `json:"children,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (n TelegraphNode) MarshalJSON() ([]byte, error) {
+	if n.Tag == "" {
+		return marshalJSON(n.Text)
+	}
+	e := telegraphElement{Tag: n.Tag, Children: n.Children}
+	if n.Href != "" || n.Src != "" {
+		e.Attrs = map[string]string{}
+		if n.Href != "" {
+			e.Attrs["href"] = n.Href
+		}
+		if n.Src != "" {
+			e.Attrs["src"] = n.Src
+		}
+	}
+	return marshalJSON(e)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *TelegraphNode) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte{'"'}) {
+		*n = TelegraphNode{}
+		return json.Unmarshal(data, &n.Text)
+	}
+	var e telegraphElement
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	*n = TelegraphNode{Tag: e.Tag, Href: e.Attrs["href"], Src: e.Attrs["src"], Children: e.Children}
+	return nil
+}
+
+// marshalJSON encodes v without escaping HTML characters, which would
+// count against TelegraphContentLimit.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{NewLineChar.Byte()}), nil
+}
+
+// TelegraphPage is a document converted to the content of a Telegraph page.
+type TelegraphPage struct {
+	// Content is the content parameter of createPage and editPage.
+	Content []TelegraphNode
+	// Size is the length of Content encoded as JSON, in bytes.
+	Size int
+	// Fitting is the number of leading nodes of Content whose JSON fits in
+	// TelegraphContentLimit. The rest can be published on another page.
+	Fitting int
+}
+
+// Fits reports whether the page is within TelegraphContentLimit.
+func (p *TelegraphPage) Fits() bool {
+	return p.Size <= TelegraphContentLimit
+}
+
+// ConvertTelegraph converts source to the content of a Telegraph page.
+// Headings of levels 1 and 2 become h3 and the others h4; spoilers are
+// written as plain text and tables as configured with WithTableStyle.
+func ConvertTelegraph(source []byte, opts ...Option) (*TelegraphPage, error) {
+	var buf bytes.Buffer
+	nr := &telegraphRenderer{config: newConfig(opts...)}
+	md := goldmark.New(
+		goldmark.WithRenderer(renderer.NewRenderer(
+			renderer.WithNodeRenderers(util.Prioritized(nr, 1000)),
+		)),
+		goldmark.WithExtensions(extensions(nr.config)...),
+	)
+	if err := md.Convert(source, &buf); err != nil {
+		return nil, err
+	}
+
+	page := &TelegraphPage{Content: nr.root.Children, Size: buf.Len()}
+	size := len("[]")
+	for i, n := range page.Content {
+		b, err := marshalJSON(n)
+		if err != nil {
+			return nil, err
+		}
+		size += len(b)
+		if i > 0 {
+			size++
+		}
+		if size > TelegraphContentLimit {
+			break
+		}
+		page.Fitting++
+	}
+	return page, nil
+}
+
+// TGTelegraph returns a new Goldmark instance that renders the content of
+// a Telegraph page.
+func TGTelegraph(opts ...Option) goldmark.Markdown {
+	return goldmark.New(
+		goldmark.WithRenderer(NewTelegraphRenderer(opts...)),
+		goldmark.WithExtensions(extensions(newConfig(opts...))...),
+	)
+}
+
+// NewTelegraphRenderer returns a new renderer.Renderer that writes the
+// content of a Telegraph page as a JSON array of nodes.
+func NewTelegraphRenderer(opts ...Option) renderer.Renderer {
+	return renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(&telegraphRenderer{config: newConfig(opts...)}, 1000),
+		),
+	)
+}
+
+// telegraphRenderer implement renderer.NodeRenderer object that builds the
+// Telegraph nodes of a single document.
+type telegraphRenderer struct {
+	config *config
+	root   TelegraphNode
+	// stack holds the open elements, innermost last.
+	stack []*TelegraphNode
+}
+
+// RegisterFuncs add AST objects to telegraphRenderer.
+func (r *telegraphRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = errorReporter{reg}
+	reg.Register(ast.KindDocument, r.document)
+	reg.Register(ast.KindParagraph, r.paragraph)
+
+	reg.Register(ast.KindText, r.renderText)
+	reg.Register(ast.KindString, r.renderString)
+	reg.Register(ast.KindEmphasis, r.emphasis)
+
+	reg.Register(ast.KindHeading, r.heading)
+	reg.Register(ast.KindList, r.renderList)
+	reg.Register(ast.KindListItem, r.listItem)
+	reg.Register(ast.KindLink, r.link)
+	reg.Register(ast.KindImage, r.image)
+
+	reg.Register(ast.KindBlockquote, r.blockquote)
+	reg.Register(ast.KindFencedCodeBlock, r.code)
+	reg.Register(ast.KindCodeBlock, r.code)
+	reg.Register(ast.KindThematicBreak, r.thematicBreak)
+	reg.Register(ast.KindHTMLBlock, r.htmlBlock)
+	reg.Register(ast.KindRawHTML, r.rawHTML)
+	reg.Register(ast.KindAutoLink, r.autoLink)
+	reg.Register(ast.KindCodeSpan, r.codeSpan)
+
+	reg.Register(ext.KindStrikethrough, r.strikethrough)
+	reg.Register(ext.KindTaskCheckBox, r.taskCheckBox)
+	reg.Register(ext.KindTable, r.table)
+	reg.Register(KindHidden, r.hidden)
+	reg.Register(KindUnderline, r.underline)
+	reg.Register(KindDoubleSpace, r.doubleSpace)
+}
+
+// top returns the innermost open element.
+func (r *telegraphRenderer) top() *TelegraphNode {
+	return r.stack[len(r.stack)-1]
+}
+
+// open appends an element to the innermost one and makes it the innermost.
+func (r *telegraphRenderer) open(tag string) *TelegraphNode {
+	parent := r.top()
+	parent.Children = append(parent.Children, TelegraphNode{Tag: tag})
+	e := &parent.Children[len(parent.Children)-1]
+	r.stack = append(r.stack, e)
+	return e
+}
+
+// close ends the innermost element.
+func (r *telegraphRenderer) close() {
+	r.stack = r.stack[:len(r.stack)-1]
+}
+
+// element opens or closes an element depending on entering.
+func (r *telegraphRenderer) element(tag string, entering bool) {
+	if entering {
+		r.open(tag)
+	} else {
+		r.close()
+	}
+}
+
+// leaf appends an element without children, such as br or hr.
+func (r *telegraphRenderer) leaf(tag string) {
+	r.open(tag)
+	r.close()
+}
+
+// text appends text to the innermost element.
+func (r *telegraphRenderer) text(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	parent := r.top()
+	if last := len(parent.Children) - 1; last >= 0 && parent.Children[last].Tag == "" {
+		parent.Children[last].Text += string(b)
+		return
+	}
+	parent.Children = append(parent.Children, TelegraphNode{Text: string(b)})
+}
+
+// soleImage returns the image that is the only content of paragraph n,
+// which is written as a figure, or nil.
+func soleImage(n ast.Node) *ast.Image {
+	img, ok := n.FirstChild().(*ast.Image)
+	if !ok || n.ChildCount() != 1 || isCustomEmoji(img) {
+		return nil
+	}
+	return img
+}
+
+func (r *telegraphRenderer) paragraph(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if soleImage(node) != nil {
+		r.element("figure", entering)
+	} else {
+		r.element("p", entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) heading(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if node.(*ast.Heading).Level <= 2 {
+		r.element("h3", entering)
+	} else {
+		r.element("h4", entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) renderList(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if node.(*ast.List).IsOrdered() {
+		r.element("ol", entering)
+	} else {
+		r.element("ul", entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) listItem(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	r.element("li", entering)
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) code(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	r.open("pre")
+	r.text(bytes.TrimSuffix(codeBlockContent(source, node), []byte{NewLineChar.Byte()}))
+	r.close()
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *telegraphRenderer) renderText(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Text)
+	r.text(textValue(source, n))
+	switch {
+	case n.HardLineBreak():
+		r.leaf("br")
+	case n.SoftLineBreak():
+		r.text([]byte{NewLineChar.Byte()})
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) renderString(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		r.text(node.(*ast.String).Value)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) emphasis(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if node.(*ast.Emphasis).Level == 2 {
+		r.element("strong", entering)
+	} else {
+		r.element("em", entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) link(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Link)
+	if isInsideLink(n) {
+		return ast.WalkContinue, nil
+	}
+	if entering {
+		r.open("a").Href = string(n.Destination)
+	} else {
+		r.close()
+	}
+	return ast.WalkContinue, nil
+}
+
+// image writes an image on its own as a figure captioned with its alt text
+// or title, and an image within text as an img. Custom emoji are written
+// as their alt text.
+func (r *telegraphRenderer) image(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Image)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if isCustomEmoji(n) {
+		r.text(customEmojiAlt(source, n))
+		return ast.WalkSkipChildren, nil
+	}
+	r.open("img").Src = string(n.Destination)
+	r.close()
+	if p := n.Parent(); p.Kind() == ast.KindParagraph && soleImage(p) == n {
+		caption := plainText(source, n)
+		if len(bytes.TrimSpace(caption)) == 0 {
+			caption = n.Title
+		}
+		if len(caption) > 0 {
+			r.open("figcaption")
+			r.text(caption)
+			r.close()
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *telegraphRenderer) autoLink(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.AutoLink)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	r.open("a").Href = string(n.URL(source))
+	r.text(n.Label(source))
+	r.close()
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) thematicBreak(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		r.leaf("hr")
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) htmlBlock(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	if text := r.config.htmlBlockText(source, node.(*ast.HTMLBlock)); len(text) > 0 {
+		r.open("p")
+		r.text(text)
+		r.close()
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *telegraphRenderer) rawHTML(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.RawHTML)
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	switch r.config.htmlPolicy {
+	case HTMLEscape:
+		r.text(rawHTMLContent(source, n))
+	case HTMLTranslate:
+		if isHTMLLineBreak(source, n) {
+			r.leaf("br")
+			break
+		}
+		// Translated tags always have a partner among the siblings of n.
+		if tag, closing, ok := translatedHTMLTag(source, n); ok {
+			if name := telegraphTag(htmlTag(tag)); name != "" {
+				r.element(name, !closing)
+			}
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// telegraphTag returns the Telegraph tag matching a Telegram HTML tag, or
+// "" for tags Telegraph does not have.
+func telegraphTag(name string) string {
+	switch name {
+	case "b":
+		return "strong"
+	case "i":
+		return "em"
+	case "u", "s", "code", "pre":
+		return name
+	}
+	return ""
+}
+
+// blockquote writes nested quotes as part of the outer one.
+func (r *telegraphRenderer) blockquote(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !isNestedBlockquote(node) {
+		r.element("blockquote", entering)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) codeSpan(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	r.open("code")
+	r.text(codeSpanContent(source, node))
+	r.close()
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *telegraphRenderer) strikethrough(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	r.element("s", entering)
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) underline(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	r.element("u", entering)
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) taskCheckBox(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		glyph := utf8.AppendRune(nil, r.config.taskGlyph(node.(*ext.TaskCheckBox)))
+		r.text(append(glyph, SpaceChar.Byte()))
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) table(_ util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ext.Table)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if r.config.tableStyle == TableList {
+		r.writeTableList(source, n)
+		return ast.WalkSkipChildren, nil
+	}
+	r.open("pre")
+	r.text(StringToBytes(tableMonospace(source, n)))
+	r.close()
+	return ast.WalkSkipChildren, nil
+}
+
+// writeTableList writes every row of table n as an item of a list, one
+// field per line.
+func (r *telegraphRenderer) writeTableList(source []byte, n *ext.Table) {
+	r.open("ul")
+	for _, record := range tableRecords(source, n) {
+		r.open("li")
+		for j, field := range record {
+			if j > 0 {
+				r.leaf("br")
+			}
+			if field.key != "" {
+				r.open("strong")
+				r.text(StringToBytes(field.key))
+				r.close()
+				r.text([]byte(": "))
+			}
+			r.text(StringToBytes(field.value))
+		}
+		r.close()
+	}
+	r.close()
+}
+
+// hidden writes spoilers as plain text, Telegraph has no spoilers.
+func (r *telegraphRenderer) hidden(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, nil
+}
+
+func (r *telegraphRenderer) doubleSpace(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+	ast.WalkStatus, error,
+) {
+	return ast.WalkContinue, nil
+}
+
+// document starts a new root and writes its content as JSON when the
+// document ends.
+func (r *telegraphRenderer) document(w util.BufWriter, _ []byte, _ ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if entering {
+		r.root = TelegraphNode{}
+		r.stack = []*TelegraphNode{&r.root}
+		return ast.WalkContinue, nil
+	}
+	content := r.root.Children
+	if content == nil {
+		content = []TelegraphNode{}
+	}
+	b, err := marshalJSON(content)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, writeRowBytes(w, b)
+}
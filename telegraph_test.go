@@ -0,0 +1,124 @@
+package tgmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+func TestConvertTelegraph(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		opts     []tgmd.Option
+		expected string
+	}{
+		{
+			name:     "Headings",
+			input:    "# One\n\n## Two\n\n### Three\n\n###### Six",
+			expected: `[{"tag":"h3","children":["One"]},{"tag":"h3","children":["Two"]},{"tag":"h4","children":["Three"]},{"tag":"h4","children":["Six"]}]`,
+		},
+		{
+			name:     "Inline Formatting",
+			input:    "**b** *i* ~~s~~ ++u++ ||spoiler|| `a<b` [link](https://example.com/?a=1&b=2)",
+			expected: `[{"tag":"p","children":[{"tag":"strong","children":["b"]}," ",{"tag":"em","children":["i"]}," ",{"tag":"s","children":["s"]}," ",{"tag":"u","children":["u"]}," spoiler ",{"tag":"code","children":["a<b"]}," ",{"tag":"a","attrs":{"href":"https://example.com/?a=1&b=2"},"children":["link"]}]}]`,
+		},
+		{
+			name:     "Line Breaks",
+			input:    "soft\nbreak  \nhard",
+			expected: `[{"tag":"p","children":["soft\nbreak",{"tag":"br"},"hard"]}]`,
+		},
+		{
+			name:     "Lists",
+			input:    "- [x] done\n- item\n\n1. first",
+			expected: `[{"tag":"ul","children":[{"tag":"li","children":["☑ done"]},{"tag":"li","children":["item"]}]},{"tag":"ol","children":[{"tag":"li","children":["first"]}]}]`,
+		},
+		{
+			name:     "Code Block and Divider",
+			input:    "```go\nfmt.Println(\"hi\")\n```\n\n---",
+			expected: `[{"tag":"pre","children":["fmt.Println(\"hi\")"]},{"tag":"hr"}]`,
+		},
+		{
+			name:     "Nested Quote",
+			input:    "> outer\n> > inner",
+			expected: `[{"tag":"blockquote","children":[{"tag":"p","children":["outer"]},{"tag":"p","children":["inner"]}]}]`,
+		},
+		{
+			name:     "Images",
+			input:    "![Screenshot](a.png)\n\n![](b.png \"Title\")\n\nInline ![icon](c.png) :emoji[42]:",
+			expected: `[{"tag":"figure","children":[{"tag":"img","attrs":{"src":"a.png"}},{"tag":"figcaption","children":["Screenshot"]}]},{"tag":"figure","children":[{"tag":"img","attrs":{"src":"b.png"}},{"tag":"figcaption","children":["Title"]}]},{"tag":"p","children":["Inline ",{"tag":"img","attrs":{"src":"c.png"}}," ⭐"]}]`,
+		},
+		{
+			name:     "Table as List",
+			input:    "| Name | Score |\n|------|-------|\n| Alice | 10 |",
+			opts:     []tgmd.Option{tgmd.WithTableStyle(tgmd.TableList)},
+			expected: `[{"tag":"ul","children":[{"tag":"li","children":[{"tag":"strong","children":["Name"]},": Alice",{"tag":"br"},{"tag":"strong","children":["Score"]},": 10"]}]}]`,
+		},
+		{
+			name:     "Translated Raw HTML",
+			input:    "<b>b</b> <tg-spoiler>s</tg-spoiler> a<br>b",
+			opts:     []tgmd.Option{tgmd.WithHTMLPolicy(tgmd.HTMLTranslate)},
+			expected: `[{"tag":"p","children":[{"tag":"strong","children":["b"]}," s a",{"tag":"br"},"b"]}]`,
+		},
+		{
+			name:     "Empty Document",
+			input:    "",
+			expected: `[]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tgmd.TGTelegraph(tc.opts...).Convert([]byte(tc.input), &buf); err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("Content mismatch:\nExpected: %s\nGot:      %s", tc.expected, buf.String())
+			}
+
+			page, err := tgmd.ConvertTelegraph([]byte(tc.input), tc.opts...)
+			if err != nil {
+				t.Fatalf("ConvertTelegraph failed: %v", err)
+			}
+			var decoded []tgmd.TelegraphNode
+			if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if len(decoded) > 0 && !reflect.DeepEqual(decoded, page.Content) {
+				t.Errorf("Decoded content mismatch:\nExpected: %+v\nGot:      %+v", page.Content, decoded)
+			}
+			if page.Size != buf.Len() || !page.Fits() || page.Fitting != len(page.Content) {
+				t.Errorf("Expected the page of %d bytes to fit: %+v", buf.Len(), page)
+			}
+		})
+	}
+}
+
+func TestConvertTelegraph_Size(t *testing.T) {
+	paragraph := strings.Repeat("a", 1000)
+	source := strings.Repeat(paragraph+"\n\n", 100)
+	page, err := tgmd.ConvertTelegraph([]byte(source))
+	if err != nil {
+		t.Fatalf("ConvertTelegraph failed: %v", err)
+	}
+	encoded, err := json.Marshal(page.Content)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if page.Size != len(encoded) {
+		t.Errorf("Size mismatch: expected %d, got %d", len(encoded), page.Size)
+	}
+	if page.Fits() {
+		t.Errorf("Expected %d bytes not to fit", page.Size)
+	}
+	// Every paragraph takes len(`{"tag":"p","children":[""]}`) + 1000 bytes
+	// and a comma.
+	if expected := (tgmd.TelegraphContentLimit - 2 + 1) / (1000 + 27 + 1); page.Fitting != expected {
+		t.Errorf("Fitting mismatch: expected %d, got %d", expected, page.Fitting)
+	}
+}
@@ -3,21 +3,37 @@ package tgmd
 import (
 	"bytes"
 	"io"
+	"unicode/utf8"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
 	ext "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
 	textm "github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
 )
 
 // Convert is a custom function that wraps the standard Goldmark conversion.
-// It allows for post-processing to quote the entire document.
+// It allows for post-processing to quote the entire document. In strict
+// mode it fails with a *StrictError when the document has losses.
 func Convert(source []byte, opts ...Option) ([]byte, error) {
+	opts, _, err := withFrontMatter(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	return convert(source, opts)
+}
+
+// convert is Convert with the front matter already applied to opts.
+func convert(source []byte, opts []Option) ([]byte, error) {
 	var buf bytes.Buffer
 	md := TGMD(opts...)
-	if err := md.Convert(source, &buf); err != nil {
+	pc := parser.NewContext()
+	if err := md.Convert(source, &buf, parser.WithContext(pc)); err != nil {
+		return nil, err
+	}
+	if err := newConfig(opts...).checkLosses(pc); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -39,57 +55,61 @@ func (r *quoteRenderer) Render(w io.Writer, source []byte, n ast.Node) error {
 		return err
 	}
 
-	contentToProcess := buf.Bytes()
-	if buf.Len() == 0 && len(source) > 0 {
-		contentToProcess = source
-	}
+	_, err := w.Write(quoteLines(buf.Bytes(), r.cfg.Quote.Expandable))
+	return err
+}
 
-	// This is the logic from QuoteDocument
-	processedBuf := bytes.TrimRight(contentToProcess, "\n")
-	if len(processedBuf) == 0 {
-		_, err := w.Write([]byte{})
-		return err
+// quoteLines prefixes every line of content with '>', wrapping the result
+// in "**" and "||" when the quote is expandable. Lines inside a pre block
+// are left alone: Telegram reads a leading '>' there as code, and the pre
+// entity keeps the quote open until its closing fence. Trailing newlines
+// are dropped; empty content yields nothing.
+func quoteLines(content []byte, expandable bool) []byte {
+	content = bytes.TrimRight(content, "\n")
+	if len(content) == 0 {
+		return nil
 	}
 
-	lines := bytes.Split(processedBuf, []byte{'\n'})
-
 	var result bytes.Buffer
-	if r.cfg.Quote.Expandable {
-		result.Write([]byte{'*', '*'})
-	}
-
-	for i, line := range lines {
-		result.WriteByte(GreaterThanChar.Byte())
-		result.Write(line)
-		if i < len(lines)-1 {
-			result.WriteByte(NewLineChar.Byte())
+	if expandable {
+		result.Write([]byte{AsteriskChar.Byte(), AsteriskChar.Byte()})
+	}
+	result.WriteByte(GreaterThanChar.Byte())
+
+	pos, inPre := 0, false
+	for _, t := range scanMarkdownV2(content) {
+		switch {
+		case t.kind == mdv2Open && t.entity == EntityPre:
+			inPre = true
+		case t.kind == mdv2Close && t.entity == EntityPre:
+			inPre = false
+		case t.isNewLine(content) && !inPre:
+			result.Write(content[pos:t.end])
+			result.WriteByte(GreaterThanChar.Byte())
+			pos = t.end
 		}
 	}
+	result.Write(content[pos:])
 
-	if r.cfg.Quote.Expandable {
-		result.Write([]byte{'|', '|'})
+	if expandable {
+		result.Write(HiddenTg.Bytes())
 	}
-
-	_, err := w.Write(result.Bytes())
-	return err
+	return result.Bytes()
 }
 
 // NewRenderer returns a new renderer.Renderer that renders Telegram Markdown.
 func NewRenderer(opts ...Option) renderer.Renderer {
-	cfg := *Config
-	for _, opt := range opts {
-		opt(&cfg)
-	}
+	cfg := newConfig(opts...)
 	r := renderer.NewRenderer(
 		renderer.WithNodeRenderers(
-			util.Prioritized(newTgmdNodeRenderer(&cfg), 1000),
+			util.Prioritized(newTgmdNodeRenderer(cfg), 1000),
 		),
 	)
 
 	if cfg.Quote.Enable {
 		return &quoteRenderer{
 			Renderer: r,
-			cfg:      &cfg,
+			cfg:      cfg,
 		}
 	}
 	return r
@@ -99,26 +119,73 @@ func NewRenderer(opts ...Option) renderer.Renderer {
 func TGMD(opts ...Option) goldmark.Markdown {
 	return goldmark.New(
 		goldmark.WithRenderer(NewRenderer(opts...)),
-		goldmark.WithExtensions(
-			Strikethroughs,
-			Hidden,
-			DoubleSpace,
-		),
+		goldmark.WithExtensions(extensions(newConfig(opts...))...),
 	)
 }
 
+// extensions returns the goldmark extensions used by every output mode.
+func extensions(cfg *config) []goldmark.Extender {
+	exts := []goldmark.Extender{
+		Strikethroughs,
+		Hidden,
+		DoubleSpace,
+		TaskList,
+		Tables,
+		CustomEmojis,
+		Mentions,
+		ExpandableQuotes,
+		NewUnderlineExtension(cfg.underlineSyntax),
+		&htmlBlocks{config: cfg},
+		&lossReports{config: cfg},
+	}
+	if cfg.imageMode == ImageCollect {
+		exts = append(exts, collectImages)
+	}
+	if cfg.frontMatter {
+		exts = append(exts, stripFrontMatter)
+	}
+	if cfg.buttons {
+		exts = append(exts, collectButtons)
+	}
+	if cfg.linkPreview != nil {
+		exts = append(exts, &linkPreviews{config: cfg.linkPreview})
+	}
+	return exts
+}
+
 // Renderer implement renderer.NodeRenderer object.
 type Renderer struct {
 	config *config
+	// sub renders parts of the tree on their own, e.g. to prefix every
+	// line of a blockquote.
+	sub renderer.Renderer
 }
 
 // newTgmdNodeRenderer initialize Renderer as renderer.NodeRenderer.
 func newTgmdNodeRenderer(config *config) renderer.NodeRenderer {
-	return &Renderer{config: config}
+	r := &Renderer{config: config}
+	r.sub = renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(r, 1000),
+		),
+	)
+	return r
+}
+
+// renderChildren renders the children of n into a separate buffer.
+func (r *Renderer) renderChildren(source []byte, n ast.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.sub.Render(&buf, source, c); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
 }
 
 // RegisterFuncs add AST objects to Renderer.
 func (r *Renderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = errorReporter{reg}
 	reg.Register(ast.KindDocument, r.document)
 	reg.Register(ast.KindParagraph, r.paragraph)
 
@@ -130,13 +197,22 @@ func (r *Renderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(ast.KindList, r.renderList) // Changed r.list to r.renderList
 	reg.Register(ast.KindListItem, r.listItem)
 	reg.Register(ast.KindLink, r.link)
+	reg.Register(ast.KindImage, r.image)
 
 	reg.Register(ast.KindBlockquote, r.blockquote)
 	reg.Register(ast.KindFencedCodeBlock, r.code)
+	reg.Register(ast.KindCodeBlock, r.code)
+	reg.Register(ast.KindThematicBreak, r.thematicBreak)
+	reg.Register(ast.KindHTMLBlock, r.htmlBlock)
+	reg.Register(ast.KindRawHTML, r.rawHTML)
+	reg.Register(ast.KindAutoLink, r.autoLink)
 	reg.Register(ast.KindCodeSpan, r.codeSpan)
 
 	reg.Register(ext.KindStrikethrough, r.strikethrough)
+	reg.Register(ext.KindTaskCheckBox, r.taskCheckBox)
+	reg.Register(ext.KindTable, r.table)
 	reg.Register(KindHidden, r.hidden)
+	reg.Register(KindUnderline, r.underline)
 	reg.Register(KindDoubleSpace, r.doubleSpace)
 }
 
@@ -169,32 +245,137 @@ func isFirstVisibleBlock(node ast.Node) bool {
 	return false
 }
 
-// writeBlockSeparationNewLines handles the newline logic for block elements.
-func writeBlockSeparationNewLines(w util.BufWriter, n ast.Node) {
-	if isFirstVisibleBlock(n) {
+// isFirstInContainer reports whether n is the first block of a blockquote
+// or list item, which starts right after the '>' or the bullet.
+func isFirstInContainer(n ast.Node) bool {
+	parent := n.Parent()
+	return parent != nil && n.PreviousSibling() == nil &&
+		(parent.Kind() == ast.KindBlockquote || parent.Kind() == ast.KindListItem)
+}
+
+// blockSeparation returns the number of newlines to put before block element n.
+func blockSeparation(n ast.Node) int {
+	if isFirstVisibleBlock(n) || isFirstInContainer(n) {
 		// No leading newlines for the very first visible block
-		return
+		return 0
 	}
 	if n.HasBlankPreviousLines() {
-		writeNewLine(w)
-		writeNewLine(w)
-	} else if n.PreviousSibling() != nil && !isEffectivelyEmpty(n.PreviousSibling()) {
+		return 2
+	}
+	if n.PreviousSibling() != nil && !isEffectivelyEmpty(n.PreviousSibling()) {
 		// Single newline if immediately follows another non-empty block
-		writeNewLine(w)
+		return 1
+	}
+	return 0
+}
+
+// paragraphSeparation returns the number of newlines to put before paragraph n.
+func paragraphSeparation(n *ast.Paragraph) int {
+	// Rule 0: Skip empty first paragraph in document (BOM handling)
+	if n.PreviousSibling() == nil && n.Parent().Kind() == ast.KindDocument && isEffectivelyEmpty(n) {
+		return 0
+	}
+
+	parentKind := n.Parent().Kind()
+	if parentKind == ast.KindListItem || parentKind == ast.KindBlockquote {
+		// Paragraphs inside ListItems or Blockquotes:
+		// Only add \n\n if the paragraph itself has HBL (blank line *within* the container).
+		// Otherwise, no leading newlines from the paragraph itself. Content flows after bullet/>.
+		// Only add \n\n if the paragraph has HBL AND it's not the first child of its container.
+		// (n.PreviousSibling() == nil implies it's the first child paragraph within the container)
+		// A paragraph right after another block, such as a heading, starts a new line.
+		switch {
+		case n.PreviousSibling() == nil:
+			return 0
+		case n.HasBlankPreviousLines():
+			return 2
+		}
+		return 1
+	}
+	return blockSeparation(n)
+}
+
+// listItemSeparation returns the number of newlines to put before list item n.
+// Newlines before the first item of a list are handled by the list itself.
+func listItemSeparation(n *ast.ListItem) int {
+	if n.PreviousSibling() == nil {
+		return 0
+	}
+	if n.HasBlankPreviousLines() { // If blank lines were present in source between items
+		return 2
+	}
+	return 1 // Default single newline between items
+}
+
+// listLevel returns the zero-based nesting level of list item n.
+func listLevel(n *ast.ListItem) int {
+	level := -1
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindList {
+			level++
+		}
 	}
+	return level
 }
 
-func (r *Renderer) heading(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
+// listItemPrefix returns the indentation and the bullet or number written
+// before list item n. The result is not escaped.
+func (c *config) listItemPrefix(n *ast.ListItem) []byte {
+	level := listLevel(n)
+	prefix := SpaceChar.Bytes((level * 2) + 2)
+	if list, ok := n.Parent().(*ast.List); ok && list.IsOrdered() {
+		number := list.Start
+		for p := n.PreviousSibling(); p != nil; p = p.PreviousSibling() {
+			number++
+		}
+		prefix = append(prefix, c.numberFormat.Format(number)...)
+	} else if box := taskCheckBox(n); box != nil {
+		prefix = utf8.AppendRune(prefix, c.taskGlyph(box))
+	} else {
+		prefix = utf8.AppendRune(prefix, c.listBullet(level))
+	}
+	return append(prefix, SpaceChar.Byte()) // Single space after bullet
+}
+
+// hasManyChildren reports whether n has more than one child. The sibling
+// links are used instead of ChildCount, which goldmark overcounts when a
+// table is inserted after the last block.
+func hasManyChildren(n ast.Node) bool {
+	return n.FirstChild() != nil && n.FirstChild() != n.LastChild()
+}
+
+// hasManyBlocks reports whether document n is written as more than one
+// block, counting the hashtags ending it.
+func hasManyBlocks(n ast.Node, c *config) bool {
+	return hasManyChildren(n) || n.HasChildren() && c.hashtagLine() != nil
+}
+
+// writeBlockSeparationNewLines handles the newline logic for block elements.
+func writeBlockSeparationNewLines(w util.BufWriter, n ast.Node) error {
+	return writeNewLines(w, blockSeparation(n))
+}
+
+func (r *Renderer) heading(w util.BufWriter, source []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
 	n := node.(*ast.Heading)
+	e := r.config.headings[n.Level-1]
 	if entering {
-		writeBlockSeparationNewLines(w, n)
-		r.config.headings[n.Level-1].writeStart(w)
-	} else {
-		r.config.headings[n.Level-1].writeEnd(w)
+		if err := writeBlockSeparationNewLines(w, n); err != nil {
+			return ast.WalkStop, err
+		}
 	}
-	return ast.WalkContinue, nil
+	if !n.HasChildren() && e.Prefix == "" && e.Postfix == "" {
+		// An empty "__" would open an underline instead.
+		return ast.WalkContinue, nil
+	}
+	if entering {
+		return ast.WalkContinue, e.writeStart(w)
+	}
+	if err := r.writeUnderscoreSeparator(w, source, n, entering); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, e.writeEnd(w)
 }
 
 func (r *Renderer) paragraph(w util.BufWriter, source []byte, node ast.Node, entering bool) (
@@ -202,33 +383,7 @@ func (r *Renderer) paragraph(w util.BufWriter, source []byte, node ast.Node, ent
 ) {
 	n := node.(*ast.Paragraph)
 	if entering {
-		// Rule 0: Skip empty first paragraph in document (BOM handling)
-		if n.PreviousSibling() == nil && n.Parent().Kind() == ast.KindDocument && isEffectivelyEmpty(n) {
-			return ast.WalkContinue, nil
-		}
-
-		parentKind := n.Parent().Kind()
-
-		if parentKind == ast.KindListItem || parentKind == ast.KindBlockquote {
-			// Paragraphs inside ListItems or Blockquotes:
-			// Only add \n\n if the paragraph itself has HBL (blank line *within* the container).
-			// Otherwise, no leading newlines from the paragraph itself. Content flows after bullet/>.
-			// Only add \n\n if the paragraph has HBL AND it's not the first child of its container.
-			// (n.PreviousSibling() == nil implies it's the first child paragraph within the container)
-			if n.HasBlankPreviousLines() && n.PreviousSibling() != nil {
-				writeNewLine(w)
-				writeNewLine(w)
-			}
-		} else {
-			if !isFirstVisibleBlock(n) { // Not the first visible block in the document
-				if n.HasBlankPreviousLines() { // Preceded by blank line(s) in source
-					writeNewLine(w)
-					writeNewLine(w)
-				} else if n.PreviousSibling() != nil && !isEffectivelyEmpty(n.PreviousSibling()) { // Immediately follows another non-empty block
-					writeNewLine(w)
-				}
-			}
-		}
+		return ast.WalkContinue, writeNewLines(w, paragraphSeparation(n))
 	}
 	return ast.WalkContinue, nil
 }
@@ -240,7 +395,7 @@ func (r *Renderer) renderList(w util.BufWriter, source []byte, node ast.Node, en
 ) {
 	if entering {
 		n := node.(*ast.List)
-		writeBlockSeparationNewLines(w, n)
+		return ast.WalkContinue, writeBlockSeparationNewLines(w, n)
 	}
 	return ast.WalkContinue, nil
 }
@@ -250,35 +405,11 @@ func (r *Renderer) listItem(w util.BufWriter, source []byte, node ast.Node, ente
 ) {
 	n := node.(*ast.ListItem)
 	if entering {
-		// Newline separation from previous item
-		if n.PreviousSibling() != nil { // If not the first list item in this list
-			if n.HasBlankPreviousLines() { // If blank lines were present in source between items
-				writeNewLine(w)
-				writeNewLine(w)
-			} else {
-				writeNewLine(w) // Default single newline between items
-			}
-		}
-		// Else (it's the first list item), newlines before the whole list are handled by renderList's writeBlockSeparationNewLines
-
-		// Indentation and bullet logic
-		listLevel := -1
-		for p := n.Parent(); p != nil; p = p.Parent() {
-			if p.Kind() == ast.KindList {
-				listLevel++
-			}
-		}
-
-		bulletIndex := listLevel
-		if bulletIndex >= len(r.config.listBullets) {
-			bulletIndex = len(r.config.listBullets) - 1
+		if err := writeNewLines(w, listItemSeparation(n)); err != nil {
+			return ast.WalkStop, err
 		}
 
-		indentation := (listLevel * 2) + 2
-
-		writeRowBytes(w, SpaceChar.Bytes(indentation))
-		writeRune(w, r.config.listBullets[bulletIndex])
-		writeRowBytes(w, SpaceChar.Bytes(1)) // Single space after bullet
+		return ast.WalkContinue, writeCustomBytes(w, r.config.listItemPrefix(n))
 	}
 	return ast.WalkContinue, nil
 }
@@ -286,31 +417,66 @@ func (r *Renderer) listItem(w util.BufWriter, source []byte, node ast.Node, ente
 func (r *Renderer) code(w util.BufWriter, source []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
-	n := node.(interface {
-		Lines() *textm.Segments
-	})
+	if entering {
+		if err := writeBlockSeparationNewLines(w, node); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeRowBytes(w, CodeTg.Bytes()); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeCodeBytes(w, codeLanguage(source, node)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeNewLine(w)
+	}
+	if err := writeCodeBytes(w, codeBlockContent(source, node)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, writeRowBytes(w, CodeTg.Bytes())
+}
+
+// codeLanguage returns the language of a fenced code block. Indented code
+// blocks have none.
+func codeLanguage(source []byte, n ast.Node) []byte {
+	if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+		return fenced.Language(source)
+	}
+	return nil
+}
+
+// codeBlockContent returns the raw lines of a code block with tabs expanded.
+func codeBlockContent(source []byte, n interface{ Lines() *textm.Segments }) []byte {
 	var content []byte
 	l := n.Lines().Len()
 	for i := range l {
 		line := n.Lines().At(i)
 		content = append(content, line.Value(source)...)
 	}
-	content = bytes.ReplaceAll(
+	return bytes.ReplaceAll(
 		content,
 		[]byte{TabChar.Byte()},
 		[]byte{SpaceChar.Byte(), SpaceChar.Byte(), SpaceChar.Byte()},
 	)
-	nn := node.(*ast.FencedCodeBlock)
-	if entering {
-		writeBlockSeparationNewLines(w, nn)
-		writeWrapperArr(w.Write(CodeTg.Bytes()))
-		writeWrapperArr(w.Write(nn.Language(source)))
-		writeNewLine(w)
-	} else {
-		writeWrapperArr(w.Write(content))
-		writeWrapperArr(w.Write(CodeTg.Bytes()))
+}
+
+// codeSpanContent returns the raw text of a code span. Line endings inside
+// the span are turned into spaces.
+func codeSpanContent(source []byte, n ast.Node) []byte {
+	var content []byte
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		var value []byte
+		switch t := c.(type) {
+		case *ast.Text:
+			value = t.Segment.Value(source)
+		case *ast.String:
+			value = t.Value
+		}
+		if bytes.HasSuffix(value, []byte{NewLineChar.Byte()}) {
+			value = append(value[:len(value)-1:len(value)-1], SpaceChar.Byte())
+		}
+		content = append(content, value...)
 	}
-	return ast.WalkContinue, nil
+	return content
 }
 
 func (r *Renderer) renderText(w util.BufWriter, source []byte, node ast.Node, entering bool) (
@@ -320,13 +486,24 @@ func (r *Renderer) renderText(w util.BufWriter, source []byte, node ast.Node, en
 		return ast.WalkContinue, nil
 	}
 	n := node.(*ast.Text)
-	render(w, n.Segment.Value(source))
+	if err := render(w, textValue(source, n)); err != nil {
+		return ast.WalkStop, err
+	}
 	if n.SoftLineBreak() || n.HardLineBreak() {
-		writeNewLine(w)
+		return ast.WalkContinue, writeNewLine(w)
 	}
 	return ast.WalkContinue, nil
 }
 
+// textValue returns the text of n with backslash escapes resolved, unless
+// the text is raw.
+func textValue(source []byte, n *ast.Text) []byte {
+	if n.IsRaw() {
+		return n.Segment.Value(source)
+	}
+	return util.UnescapePunctuations(n.Segment.Value(source))
+}
+
 func (r *Renderer) renderString(w util.BufWriter, source []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
@@ -334,66 +511,349 @@ func (r *Renderer) renderString(w util.BufWriter, source []byte, node ast.Node,
 		return ast.WalkContinue, nil
 	}
 	n := node.(*ast.String)
-	_, _ = w.Write(n.Value)
-	return ast.WalkContinue, nil
+	return ast.WalkContinue, writeRowBytes(w, n.Value)
 }
 
-func (r *Renderer) emphasis(w util.BufWriter, _ []byte, node ast.Node, _ bool) (
+func (r *Renderer) emphasis(w util.BufWriter, source []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
 	n := node.(*ast.Emphasis)
+	if isNestedFormatting(n) {
+		return ast.WalkContinue, nil
+	}
 	if n.Level == 2 {
-		writeRowBytes(w, BoldTg.Bytes())
+		return ast.WalkContinue, writeRowBytes(w, BoldTg.Bytes())
 	}
 	if n.Level == 1 {
-		writeRowBytes(w, ItalicsTg.Bytes())
+		if err := r.writeUnderscoreSeparator(w, source, n, entering); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeRowBytes(w, ItalicsTg.Bytes())
 	}
 	return ast.WalkContinue, nil
 }
 
+func (r *Renderer) underline(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if isNestedFormatting(node) {
+		return ast.WalkContinue, nil
+	}
+	if err := r.writeUnderscoreSeparator(w, source, node, entering); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, writeRowBytes(w, UnderlineTg.Bytes())
+}
+
+// isNestedFormatting reports whether n is inside a node with the same
+// formatting. MarkdownV2 markup toggles, so only the outer one is written:
+// "*a **b *c* d** e*" would otherwise become "_a *b _c_ d* e_", where the
+// inner "_" closes the italic entity inside the bold one and Telegram
+// rejects the message.
+func isNestedFormatting(n ast.Node) bool {
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() != n.Kind() {
+			continue
+		}
+		e, ok := n.(*ast.Emphasis)
+		if !ok || e.Level == p.(*ast.Emphasis).Level {
+			return true
+		}
+	}
+	return false
+}
+
+// writeUnderscoreSeparator writes the character Telegram ignores between
+// underscore markups that would otherwise merge.
+func (r *Renderer) writeUnderscoreSeparator(w util.BufWriter, source []byte, n ast.Node, entering bool) error {
+	if r.config.needsUnderscoreSeparator(source, n, entering) {
+		return writeRowBytes(w, []byte{CarriageReturnChar.Byte()})
+	}
+	return nil
+}
+
 func (r *Renderer) link(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
 	n := node.(*ast.Link)
+	if isInsideLink(n) {
+		return ast.WalkContinue, nil
+	}
 	if entering {
-		writeRowBytes(w, []byte{OpenBracketChar.Byte()})
-	} else {
-		writeRowBytes(w, []byte{CloseBracketChar.Byte(), OpenParenChar.Byte()})
-		writeRowBytes(w, n.Destination)
-		writeRowBytes(w, []byte{CloseParenChar.Byte()})
+		return ast.WalkContinue, writeRowBytes(w, []byte{OpenBracketChar.Byte()})
 	}
-	return ast.WalkContinue, nil
+	return ast.WalkContinue, writeLinkEnd(w, n.Destination)
+}
+
+// writeLinkEnd closes the text of a link and writes its destination.
+func writeLinkEnd(w util.BufWriter, destination []byte) error {
+	if err := writeRowBytes(w, []byte{CloseBracketChar.Byte(), OpenParenChar.Byte()}); err != nil {
+		return err
+	}
+	if err := writeLinkBytes(w, destination); err != nil {
+		return err
+	}
+	return writeRowBytes(w, []byte{CloseParenChar.Byte()})
 }
 
-func (r *Renderer) blockquote(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+func (r *Renderer) image(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.Image)
+	if isCustomEmoji(n) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if err := writeRowBytes(w, []byte{ExclamationChar.Byte(), OpenBracketChar.Byte()}); err != nil {
+			return ast.WalkStop, err
+		}
+		if err := writeCustomBytes(w, customEmojiAlt(source, n)); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkSkipChildren, writeLinkEnd(w, n.Destination)
+	}
+	if r.config.imageMode == ImageCollect {
+		return ast.WalkSkipChildren, nil
+	}
+	link := !isInsideLink(n)
 	if entering {
-		writeBlockSeparationNewLines(w, n)
-		writeRowBytes(w, []byte{GreaterThanChar.Byte()})
+		if link {
+			if err := writeRowBytes(w, []byte{OpenBracketChar.Byte()}); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		if !r.config.imageAltText(source, n) {
+			return ast.WalkSkipChildren, writeCustomBytes(w, utf8.AppendRune(nil, ImageSymbol.Rune()))
+		}
+	} else if link {
+		return ast.WalkContinue, writeLinkEnd(w, n.Destination)
 	}
 	return ast.WalkContinue, nil
 }
 
-func (r *Renderer) codeSpan(w util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+func (r *Renderer) autoLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
-	writeWrapperArr(w.Write(SpanTg.Bytes()))
-	return ast.WalkContinue, nil
+	n := node.(*ast.AutoLink)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if n.AutoLinkType == ast.AutoLinkEmail {
+		// Telegram links e-mail addresses on its own.
+		return ast.WalkContinue, writeCustomBytes(w, n.Label(source))
+	}
+	if err := writeRowBytes(w, []byte{OpenBracketChar.Byte()}); err != nil {
+		return ast.WalkStop, err
+	}
+	if err := writeCustomBytes(w, n.Label(source)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkContinue, writeLinkEnd(w, n.URL(source))
 }
 
-func (r *Renderer) strikethrough(w util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+func (r *Renderer) thematicBreak(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
-	writeWrapperArr(w.Write(StrikethroughTg.Bytes()))
+	if entering && r.config.divider != "" {
+		if err := writeBlockSeparationNewLines(w, node); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeCustomBytes(w, StringToBytes(r.config.divider))
+	}
 	return ast.WalkContinue, nil
 }
 
-func (r *Renderer) hidden(w util.BufWriter, _ []byte, _ ast.Node, _ bool) (
+func (r *Renderer) htmlBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	if text := r.config.htmlBlockText(source, node.(*ast.HTMLBlock)); len(text) > 0 {
+		if err := writeBlockSeparationNewLines(w, node); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkSkipChildren, writeCustomBytes(w, text)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *Renderer) rawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ast.RawHTML)
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	switch r.config.htmlPolicy {
+	case HTMLEscape:
+		return ast.WalkSkipChildren, writeCustomBytes(w, rawHTMLContent(source, n))
+	case HTMLTranslate:
+		if isHTMLLineBreak(source, n) {
+			return ast.WalkSkipChildren, writeNewLine(w)
+		}
+		if tag, _, ok := translatedHTMLTag(source, n); ok {
+			if err := r.writeUnderscoreSeparator(w, source, n, entering); err != nil {
+				return ast.WalkStop, err
+			}
+			return ast.WalkSkipChildren, writeRowBytes(w, tag.Bytes())
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *Renderer) blockquote(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := writeBlockSeparationNewLines(w, n); err != nil {
+		return ast.WalkStop, err
+	}
+	if r.config.flattensBlockquote(n) {
+		// Telegram has no nested quotes, the content joins the outer one.
+		return ast.WalkContinue, nil
+	}
+	content, err := r.renderChildren(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, writeRowBytes(w, quoteLines(content, isExpandableBlockquote(n)))
+}
+
+// isNestedBlockquote reports whether n is inside another blockquote.
+func isNestedBlockquote(n ast.Node) bool {
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Kind() == ast.KindBlockquote {
+			return true
+		}
+	}
+	return false
+}
+
+// flattensBlockquote reports whether blockquote n is written as plain
+// lines of the quote around it. Telegram does not nest quotes, and the
+// whole document is one quote when quoting is enabled: a blockquote in a
+// quoted document would otherwise start its lines with ">>", which
+// Telegram rejects.
+func (c *config) flattensBlockquote(n ast.Node) bool {
+	return c.Quote.Enable || isNestedBlockquote(n)
+}
+
+func (r *Renderer) codeSpan(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	if err := writeRowBytes(w, SpanTg.Bytes()); err != nil {
+		return ast.WalkStop, err
+	}
+	if err := writeCodeBytes(w, codeSpanContent(source, node)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, writeRowBytes(w, SpanTg.Bytes())
+}
+
+func (r *Renderer) strikethrough(w util.BufWriter, _ []byte, node ast.Node, _ bool) (
+	ast.WalkStatus, error,
+) {
+	if isNestedFormatting(node) {
+		return ast.WalkContinue, nil
+	}
+	return ast.WalkContinue, writeRowBytes(w, StrikethroughTg.Bytes())
+}
+
+func (r *Renderer) taskCheckBox(w util.BufWriter, _ []byte, node ast.Node, entering bool) (
 	ast.WalkStatus, error,
 ) {
-	writeWrapperArr(w.Write(HiddenTg.Bytes()))
+	n := node.(*ext.TaskCheckBox)
+	if entering && !replacesBullet(n) {
+		if err := writeCustomBytes(w, utf8.AppendRune(nil, r.config.taskGlyph(n))); err != nil {
+			return ast.WalkStop, err
+		}
+		return ast.WalkContinue, writeRowBytes(w, SpaceChar.Bytes(1))
+	}
 	return ast.WalkContinue, nil
 }
 
+func (r *Renderer) table(w util.BufWriter, source []byte, node ast.Node, entering bool) (
+	ast.WalkStatus, error,
+) {
+	n := node.(*ext.Table)
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if err := writeBlockSeparationNewLines(w, n); err != nil {
+		return ast.WalkStop, err
+	}
+	if r.config.tableStyle == TableList {
+		return ast.WalkSkipChildren, r.writeTableList(w, source, n)
+	}
+	return ast.WalkSkipChildren, writeTableMonospace(w, source, n)
+}
+
+// writeTableMonospace writes table n as aligned text in a code block.
+func writeTableMonospace(w util.BufWriter, source []byte, n *ext.Table) error {
+	if err := writeRowBytes(w, CodeTg.Bytes()); err != nil {
+		return err
+	}
+	if err := writeNewLine(w); err != nil {
+		return err
+	}
+	if err := writeCodeBytes(w, StringToBytes(tableMonospace(source, n))); err != nil {
+		return err
+	}
+	if err := writeNewLine(w); err != nil {
+		return err
+	}
+	return writeRowBytes(w, CodeTg.Bytes())
+}
+
+// writeTableList writes every row of table n as a list of fields.
+func (r *Renderer) writeTableList(w util.BufWriter, source []byte, n *ext.Table) error {
+	for i, record := range tableRecords(source, n) {
+		if i > 0 {
+			if err := writeNewLine(w); err != nil {
+				return err
+			}
+		}
+		for j, field := range record {
+			if j > 0 {
+				if err := writeNewLine(w); err != nil {
+					return err
+				}
+			}
+			if err := writeCustomBytes(w, StringToBytes(r.config.tableRecordPrefix(j))); err != nil {
+				return err
+			}
+			if field.key != "" {
+				if err := writeSpecialTagStart(w, BoldTg, StringToBytes(field.key)); err != nil {
+					return err
+				}
+				if err := writeSpecialTagEnd(w, BoldTg, nil); err != nil {
+					return err
+				}
+				if err := writeCustomBytes(w, []byte(": ")); err != nil {
+					return err
+				}
+			}
+			if err := writeCustomBytes(w, StringToBytes(field.value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Renderer) hidden(w util.BufWriter, _ []byte, node ast.Node, _ bool) (
+	ast.WalkStatus, error,
+) {
+	if isNestedFormatting(node) {
+		return ast.WalkContinue, nil
+	}
+	return ast.WalkContinue, writeRowBytes(w, HiddenTg.Bytes())
+}
+
 func (r *Renderer) doubleSpace(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (
 	ast.WalkStatus, error,
 ) {
@@ -407,9 +867,20 @@ func (r *Renderer) document(w util.BufWriter, _ []byte, node ast.Node, entering
 		return ast.WalkContinue, nil
 	}
 
+	if footer := r.config.hashtagLine(); footer != nil {
+		if node.HasChildren() {
+			if err := writeNewLines(w, 2); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		if err := writeCustomBytes(w, footer); err != nil {
+			return ast.WalkStop, err
+		}
+	}
+
 	// Add a final newline for multi-block documents.
-	if node.ChildCount() > 1 {
-		writeNewLine(w)
+	if hasManyBlocks(node, r.config) {
+		return ast.WalkContinue, writeNewLine(w)
 	}
 
 	return ast.WalkContinue, nil
@@ -9,10 +9,6 @@ import (
 )
 
 func TestTGMDConvert_VariousCases(t *testing.T) {
-	// Define default configurations for restoration
-	defaultH1Config := tgmd.Element{Style: tgmd.BoldTg, Prefix: "", Postfix: ""}
-	defaultPrimaryListBullet := tgmd.CircleSymbol.Rune()
-
 	// Read source and expected result from files
 	sourceMdContent, err := os.ReadFile("example/source.md")
 	if err != nil {
@@ -27,11 +23,10 @@ func TestTGMDConvert_VariousCases(t *testing.T) {
 	normalizedResult := strings.ReplaceAll(string(sourceResultMdContent), "\r\n", "\n")
 
 	testCases := []struct {
-		name          string
-		input         string
-		setupConfig   func()
-		cleanupConfig func()
-		expected      string
+		name     string
+		input    string
+		opts     []tgmd.Option
+		expected string
 	}{
 		{
 			name:     "Single Line User Input",
@@ -60,124 +55,237 @@ func TestTGMDConvert_VariousCases(t *testing.T) {
 		{
 			name:  "Heading 1 with Custom Config (from example/main.go)",
 			input: "# Heading1 🎉",
-			setupConfig: func() {
-				tgmd.Config.UpdateHeading1(tgmd.Element{
+			opts: []tgmd.Option{
+				tgmd.WithHeading1(tgmd.Element{
 					Style:   tgmd.BoldTg,
 					Prefix:  "!!!",
 					Postfix: "!!!",
-				})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.UpdateHeading1(defaultH1Config)
+				}),
 			},
 			expected: "*\\!\\!\\!Heading1 🎉\\!\\!\\!*",
 		},
+		{
+			name:     "Empty Headings",
+			input:    "####\ntext\n\n#",
+			expected: "\ntext\n\n\n",
+		},
 		{
 			name:     "Strikethrough in paragraph",
 			input:    "~~strike~~",
 			expected: "~strike~",
 		},
+		{
+			name:     "Nested Emphasis of the Same Kind",
+			input:    "*a **b *c* d** e*",
+			expected: "_a *b c d* e_",
+		},
 		{
 			name:     "Code span in paragraph",
 			input:    "text `code` text",
 			expected: "text `code` text",
 		},
+		{
+			name:     "Code span with backtick and backslash",
+			input:    "``a`b\\c.d``",
+			expected: "`a\\`b\\\\c.d`",
+		},
+		{
+			name:     "Fenced Code Block with backticks",
+			input:    "````md\n```go\nC:\\dir\n```\n````",
+			expected: "```md\n\\`\\`\\`go\nC:\\\\dir\n\\`\\`\\`\n```",
+		},
+		{
+			name:     "Link with parentheses in URL",
+			input:    "[Go](https://en.wikipedia.org/wiki/Go_(programming_language))",
+			expected: "[Go](https://en.wikipedia.org/wiki/Go_(programming_language\\))",
+		},
 		{
 			name:     "Link in paragraph",
 			input:    "[goldmark](url)",
 			expected: "[goldmark](url)",
 		},
+		{
+			name:     "Image as Link",
+			input:    "![A *cat*.](https://example.com/cat.png)",
+			expected: "[A _cat_\\.](https://example.com/cat.png)",
+		},
+		{
+			name:     "Image without Alt Text",
+			input:    "![](cat.png)",
+			expected: "[🖼](cat.png)",
+		},
+		{
+			name:     "Image as Placeholder",
+			input:    "Look ![cat](cat.png)",
+			opts:     []tgmd.Option{tgmd.WithImageMode(tgmd.ImagePlaceholder)},
+			expected: "Look [🖼](cat.png)",
+		},
+		{
+			name:     "Image inside Link",
+			input:    "[![logo](logo.png)](https://example.com)",
+			expected: "[logo](https://example.com)",
+		},
+		{
+			name:     "Collected Images are Removed",
+			input:    "Before\n\n![cat](cat.png)\n\nAfter",
+			opts:     []tgmd.Option{tgmd.WithImageMode(tgmd.ImageCollect)},
+			expected: "Before\n\nAfter\n",
+		},
+		{
+			name:     "Thematic Break",
+			input:    "Above\n\n---\n\nBelow",
+			expected: "Above\n\n──────────\n\nBelow\n",
+		},
+		{
+			name:     "Thematic Break with Custom Divider",
+			input:    "Above\n\n***\n\nBelow",
+			opts:     []tgmd.Option{tgmd.WithDivider("- - -")},
+			expected: "Above\n\n\\- \\- \\-\n\nBelow\n",
+		},
+		{
+			name:     "Indented Code Block",
+			input:    "Run:\n\n    go test `./...`\n",
+			expected: "Run:\n\n```\ngo test \\`./...\\`\n```\n",
+		},
+		{
+			name:     "Autolink",
+			input:    "<https://example.com/a_(b)> and <me@example.com>",
+			expected: "[https://example\\.com/a\\_\\(b\\)](https://example.com/a_(b\\)) and me@example\\.com",
+		},
+		{
+			name:     "Raw HTML is Stripped",
+			input:    "<div>\nblock\n</div>\n\nSome <b>bold</b> text",
+			expected: "Some bold text",
+		},
+		{
+			name:     "Raw HTML is Escaped",
+			input:    "a <b>b</b>",
+			opts:     []tgmd.Option{tgmd.WithHTMLPolicy(tgmd.HTMLEscape)},
+			expected: "a \\<b\\>b\\</b\\>",
+		},
+		{
+			name:     "Raw HTML is Translated",
+			input:    "<strong>b</strong> <i><ins>iu</ins></i> <span>s</span> <b>open<br>x",
+			opts:     []tgmd.Option{tgmd.WithHTMLPolicy(tgmd.HTMLTranslate)},
+			expected: "*b* _\r__iu__\r_ s open\nx",
+		},
+		{
+			name:     "HTML Block is Translated to Text",
+			input:    "<div>\n<p>Hello <b>world</b>.</p>\n</div>",
+			opts:     []tgmd.Option{tgmd.WithHTMLPolicy(tgmd.HTMLTranslate)},
+			expected: "Hello world\\.",
+		},
 		{
 			name:     "Standard Blockquote",
 			input:    "> BQ",
 			expected: ">BQ",
 		},
 		{
-			name:  "Document as Quote",
-			input: "Line 1\nLine 2",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: false})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
+			name:     "Blockquote with multiple lines",
+			input:    "> Line 1\n> Line 2",
 			expected: ">Line 1\n>Line 2",
 		},
 		{
-			name:  "Document as Expandable Quote",
-			input: "Line 1\nLine 2",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: true})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
+			name:     "Blockquote with multiple paragraphs",
+			input:    "> Para 1.\n>\n> Para 2.",
+			expected: ">Para 1\\.\n>\n>Para 2\\.",
+		},
+		{
+			name:     "Blockquote with list",
+			input:    "> Items:\n>\n> - One\n> - Two\n\nAfter",
+			expected: ">Items:\n>\n>  • One\n>  • Two\n\nAfter\n",
+		},
+		{
+			name:     "Blockquote Starting with a List",
+			input:    "> - a\n> - b",
+			expected: ">  • a\n>  • b",
+		},
+		{
+			name:     "Blockquote with Heading and Text",
+			input:    "> # h\n> text",
+			expected: ">*h*\n>text",
+		},
+		{
+			name:     "Blockquote with code span",
+			input:    "> Run `go test`\n> twice.",
+			expected: ">Run `go test`\n>twice\\.",
+		},
+		{
+			name:     "Expandable Blockquote",
+			input:    "Intro\n\n> [!expand]\n> Build log:\n>\n> - step one\n> - step two\n\nAfter",
+			expected: "Intro\n\n**>Build log:\n>\n>  • step one\n>  • step two||\n\nAfter\n",
+		},
+		{
+			name:     "Expandable Blockquote with Marker on the First Line",
+			input:    "> [!EXPAND] Short log\n> line 2",
+			expected: "**>Short log\n>line 2||",
+		},
+		{
+			name:     "Expandable Blockquote Ending with Code",
+			input:    "> [!expand]\n> Log:\n> ```\n> a_b\n> ```",
+			expected: "**>Log:\n>```\na_b\n```||",
+		},
+		{
+			name:     "Blockquote of the Expand Marker Alone",
+			input:    "> [!expand]",
+			expected: ">\\[\\!expand\\]",
+		},
+		{
+			name:     "Expand Marker Inside a Blockquote",
+			input:    "> text\n> [!expand]\n\n> [!expanded]",
+			expected: ">text\n>\\[\\!expand\\]\n\n>\\[\\!expanded\\]\n",
+		},
+		{
+			name:     "Nested blockquote is flattened",
+			input:    "> Outer\n>\n> > Inner",
+			expected: ">Outer\n>\n>Inner",
+		},
+		{
+			name:     "Document as Quote",
+			input:    "Line 1\nLine 2",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: false})},
+			expected: ">Line 1\n>Line 2",
+		},
+		{
+			name:     "Document as Expandable Quote",
+			input:    "Line 1\nLine 2",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: true})},
 			expected: "**>Line 1\n>Line 2||",
 		},
 		{
-			name:  "Complex Document as Quote",
-			input: "# Title\n\n- Item 1\n- Item 2\n\nSome `code` here.",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: false})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
+			name:     "Complex Document as Quote",
+			input:    "# Title\n\n- Item 1\n- Item 2\n\nSome `code` here.",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: false})},
 			expected: ">*Title*\n>\n>  • Item 1\n>  • Item 2\n>\n>Some `code` here\\.",
 		},
 		{
-			name:  "Complex Document as Expandable Quote",
-			input: "# Title\n\n- Item 1\n- Item 2\n\nSome `code` here.",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: true})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
+			name:     "Complex Document as Expandable Quote",
+			input:    "# Title\n\n- Item 1\n- Item 2\n\nSome `code` here.",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: true})},
 			expected: "**>*Title*\n>\n>  • Item 1\n>  • Item 2\n>\n>Some `code` here\\.||",
 		},
 		{
-			name:  "Document with Existing Blockquote as Quote",
-			input: "Line 1\n\n> Nested Quote",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: false})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
-			expected: ">Line 1\n>\n>>Nested Quote",
+			name:     "Document with Existing Blockquote as Quote is Flattened",
+			input:    "Line 1\n\n> Nested Quote",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: false})},
+			expected: ">Line 1\n>\n>Nested Quote",
 		},
 		{
-			name:  "Empty Input with Quoting Enabled",
-			input: "",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: true})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
+			name:     "Empty Input with Quoting Enabled",
+			input:    "",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: true})},
 			expected: "",
 		},
 		{
-			name:  "Whitespace Input with Quoting Enabled",
-			input: "   \n\t\n ",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: true})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
-			expected: "**>   \n>\t\n> ||",
+			name:     "Whitespace Input with Quoting Enabled",
+			input:    "   \n\t\n ",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: true})},
+			expected: "",
 		},
 		{
-			name:  "Quote Enabled but Expandable Disabled with Marker",
-			input: "Hello\n**",
-			setupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: true, Expandable: false})
-			},
-			cleanupConfig: func() {
-				tgmd.Config.SetQuoteOptions(tgmd.QuoteConfig{Enable: false, Expandable: false})
-			},
+			name:     "Quote Enabled but Expandable Disabled with Marker",
+			input:    "Hello\n**",
+			opts:     []tgmd.Option{tgmd.WithQuote(tgmd.QuoteConfig{Enable: true, Expandable: false})},
 			expected: ">Hello\n>\\*\\*",
 		},
 		{
@@ -190,20 +298,130 @@ func TestTGMDConvert_VariousCases(t *testing.T) {
 			input:    "- Item 1",
 			expected: "  • Item 1",
 		},
+		{
+			name:     "Ordered List",
+			input:    "1. One\n2. Two\n3. Three",
+			expected: "  1\\. One\n  2\\. Two\n  3\\. Three",
+		},
+		{
+			name:     "Ordered List with Start",
+			input:    "7. Seven\n8. Eight",
+			expected: "  7\\. Seven\n  8\\. Eight",
+		},
+		{
+			name:     "Mixed Nested Lists",
+			input:    "1. One\n   - Sub\n   - Sub\n     1. Deep\n     2. Deep\n2. Two",
+			expected: "  1\\. One\n    ‣ Sub\n    ‣ Sub\n      1\\. Deep\n      2\\. Deep\n  2\\. Two",
+		},
+		{
+			name:     "Ordered List with Paren Format",
+			input:    "1. One\n2. Two",
+			opts:     []tgmd.Option{tgmd.WithNumberFormat(tgmd.NumberParen)},
+			expected: "  1\\) One\n  2\\) Two",
+		},
+		{
+			name:     "Ordered List with Roman Format",
+			input:    "3. Three\n4. Four",
+			opts:     []tgmd.Option{tgmd.WithNumberFormat(tgmd.NumberRoman)},
+			expected: "  III\\. Three\n  IV\\. Four",
+		},
+		{
+			name:     "Ordered List with Keycap Format",
+			input:    "1. One\n2. Two",
+			opts:     []tgmd.Option{tgmd.WithNumberFormat(tgmd.NumberKeycap)},
+			expected: "  1️⃣ One\n  2️⃣ Two",
+		},
+		{
+			name:     "Task List",
+			input:    "- [ ] Todo\n- [x] Done\n  - [ ] Nested\n- Plain",
+			expected: "  ☐ Todo\n  ☑ Done\n    ☐ Nested\n  • Plain",
+		},
+		{
+			name:     "Ordered Task List",
+			input:    "1. [x] Done\n2. [ ] Todo",
+			expected: "  1\\. ☑ Done\n  2\\. ☐ Todo",
+		},
+		{
+			name:     "Task List with Custom Check Boxes",
+			input:    "- [ ] Todo\n- [X] Done",
+			opts:     []tgmd.Option{tgmd.WithTaskCheckBoxes('⬜', '✅')},
+			expected: "  ⬜ Todo\n  ✅ Done",
+		},
+		{
+			name:     "Custom Reserved Bullet is Escaped",
+			input:    "- Item",
+			opts:     []tgmd.Option{tgmd.WithPrimaryListBullet('-')},
+			expected: "  \\- Item",
+		},
+		{
+			name:     "Underline",
+			input:    "++under++ and C++",
+			expected: "__under__ and C\\+\\+",
+		},
+		{
+			name:     "Underline with Underscore Syntax",
+			input:    "__under__ _italic_ **bold**",
+			opts:     []tgmd.Option{tgmd.WithUnderlineSyntax(tgmd.UnderlineUnderscore)},
+			expected: "__under__ _italic_ *bold*",
+		},
+		{
+			name:     "Italic Underline is Separated",
+			input:    "___both___",
+			opts:     []tgmd.Option{tgmd.WithUnderlineSyntax(tgmd.UnderlineUnderscore)},
+			expected: "_\r__both__\r_",
+		},
+		{
+			name:     "Adjacent Italic and Underline are Separated",
+			input:    "_italic_++under++",
+			expected: "_italic_\r__under__",
+		},
+		{
+			name:     "Bold Heading Ending with Italic",
+			input:    "Foo *bar*\n===",
+			expected: "*Foo _bar_*",
+		},
+		{
+			name:     "Underlined Heading Ending with Italic is Separated",
+			input:    "# Foo *bar*",
+			opts:     []tgmd.Option{tgmd.WithHeading1(tgmd.Element{Style: tgmd.UnderlineTg})},
+			expected: "__Foo _bar_\r__",
+		},
+		{
+			name:     "Table as Monospace Block",
+			input:    "| Name | Score |\n|------|------:|\n| Alice 🎉 | 10 |\n| 日本語 | 7 |",
+			expected: "```\nName     | Score\n---------|------\nAlice 🎉 |    10\n日本語   |     7\n```",
+		},
+		{
+			name:     "Table with Escaped Pipe",
+			input:    "| A | B |\n|---|:-:|\n| a\\|b | `x` |",
+			expected: "```\nA   | B\n----|--\na|b | x\n```",
+		},
+		{
+			name:     "Table as List",
+			input:    "| Name | Score |\n|------|-------|\n| Alice | 10 |\n| Bob | 7.5 |",
+			opts:     []tgmd.Option{tgmd.WithTableStyle(tgmd.TableList)},
+			expected: "  • *Name*: Alice\n    *Score*: 10\n  • *Name*: Bob\n    *Score*: 7\\.5",
+		},
+		{
+			name:     "Custom Emoji",
+			input:    "![👍](tg://emoji?id=5368324170671202286) :emoji[42]: [a ![.](tg://emoji?id=1)](https://example.com)",
+			expected: "![👍](tg://emoji?id=5368324170671202286) ![⭐](tg://emoji?id=42) [a ![\\.](tg://emoji?id=1)](https://example.com)",
+		},
+		{
+			name:     "User Mentions",
+			input:    "@[Jane Doe](user:123) and [John](tg://user?id=456)",
+			expected: "[Jane Doe](tg://user?id=123) and [John](tg://user?id=456)",
+		},
 		{
 			name:  "Full Example Source Document",
 			input: string(sourceMdContent),
-			setupConfig: func() {
-				tgmd.Config.UpdateHeading1(tgmd.Element{
+			opts: []tgmd.Option{
+				tgmd.WithHeading1(tgmd.Element{
 					Style:   tgmd.BoldTg,
 					Prefix:  "!!!",
 					Postfix: "!!!",
-				})
-				tgmd.Config.UpdatePrimaryListBullet('•')
-			},
-			cleanupConfig: func() {
-				tgmd.Config.UpdateHeading1(defaultH1Config)
-				tgmd.Config.UpdatePrimaryListBullet(defaultPrimaryListBullet)
+				}),
+				tgmd.WithPrimaryListBullet('•'),
 			},
 			expected: normalizedResult,
 		},
@@ -211,15 +429,7 @@ func TestTGMDConvert_VariousCases(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if tc.setupConfig != nil {
-				tc.setupConfig()
-			}
-			// Ensure cleanupConfig is called even if the test panics
-			if tc.cleanupConfig != nil {
-				defer tc.cleanupConfig()
-			}
-
-			got, err := tgmd.Convert([]byte(tc.input))
+			got, err := tgmd.Convert([]byte(tc.input), tc.opts...)
 			if err != nil {
 				t.Fatalf("Convert failed: %v", err)
 			}
@@ -233,6 +443,9 @@ func TestTGMDConvert_VariousCases(t *testing.T) {
 					string(got),
 				)
 			}
+			if err := tgmd.Validate(got); err != nil {
+				t.Errorf("Output is not valid MarkdownV2: %v\nGot: %q", err, string(got))
+			}
 		})
 	}
 }
@@ -0,0 +1,387 @@
+// Package tgsend sends messages converted by tgmd through the Telegram Bot
+// API. It splits long documents, waits out rate limits and, when Telegram
+// cannot parse a MarkdownV2 message, sends it again as text with entities.
+package tgsend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+// DefaultBaseURL is the address of the public Bot API server.
+const DefaultBaseURL = "https://api.telegram.org"
+
+// ParseModeMarkdownV2 is the parse mode of messages converted by tgmd.
+const ParseModeMarkdownV2 = "MarkdownV2"
+
+// Client calls the Bot API methods that send messages.
+type Client struct {
+	token      string
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+}
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the Bot API server address, e.g. a local
+// telegram-bot-api server. DefaultBaseURL is used otherwise.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sets the HTTP client making the requests.
+// http.DefaultClient is used otherwise.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = h
+	}
+}
+
+// WithMaxRetries sets how many times a request refused with 429 Too Many
+// Requests is retried after the delay Telegram asks for, 3 by default.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// New returns a client for the bot with the given token.
+func New(token string, opts ...Option) *Client {
+	c := &Client{
+		token:      token,
+		baseURL:    DefaultBaseURL,
+		httpClient: http.DefaultClient,
+		maxRetries: 3,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Request holds the sendMessage parameters other than the text.
+type Request struct {
+	// ChatID is the numeric chat identifier or the @username of a channel.
+	ChatID              string
+	MessageThreadID     int
+	DisableNotification bool
+	ProtectContent      bool
+	DisableLinkPreview  bool
+	// LinkPreview sets the link preview, e.g. tgmd.Result.LinkPreview.
+	// DisableLinkPreview overrides it.
+	LinkPreview *tgmd.LinkPreviewOptions
+	// ReplyMarkup is the inline keyboard of the message, e.g.
+	// tgmd.Result.Keyboard. Split messages carry it on the last one.
+	ReplyMarkup *tgmd.InlineKeyboardMarkup
+}
+
+// WithFrontMatter returns r with the send options set by the front matter
+// of a document, see tgmd.WithFrontMatter. Options the front matter leaves
+// unset are kept.
+func (r Request) WithFrontMatter(fm *tgmd.FrontMatter) Request {
+	if fm == nil {
+		return r
+	}
+	if fm.MessageThreadID != 0 {
+		r.MessageThreadID = int(fm.MessageThreadID)
+	}
+	r.DisableNotification = r.DisableNotification || fm.DisableNotification
+	r.ProtectContent = r.ProtectContent || fm.ProtectContent
+	r.DisableLinkPreview = r.DisableLinkPreview || fm.DisableLinkPreview
+	return r
+}
+
+// Chat is the chat a message was sent to.
+type Chat struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+// Message is the part of a Bot API Message returned for sent messages.
+type Message struct {
+	MessageID int           `json:"message_id"`
+	Chat      Chat          `json:"chat"`
+	Date      int64         `json:"date"`
+	Text      string        `json:"text"`
+	Entities  []tgmd.Entity `json:"entities,omitempty"`
+}
+
+// Error is an error returned by the Bot API.
+type Error struct {
+	Method      string
+	Code        int
+	Description string
+	// RetryAfter is the number of seconds to wait before repeating a
+	// request refused with 429 Too Many Requests.
+	RetryAfter int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tgsend: %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// IsParseError reports whether err is Telegram refusing the markup of a
+// message ("can't parse entities").
+func IsParseError(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest &&
+		strings.Contains(apiErr.Description, "can't parse entities")
+}
+
+// sendMessageParams is the body of a sendMessage request.
+type sendMessageParams struct {
+	ChatID              string                     `json:"chat_id"`
+	MessageThreadID     int                        `json:"message_thread_id,omitempty"`
+	Text                string                     `json:"text"`
+	ParseMode           string                     `json:"parse_mode,omitempty"`
+	Entities            []tgmd.Entity              `json:"entities,omitempty"`
+	DisableNotification bool                       `json:"disable_notification,omitempty"`
+	ProtectContent      bool                       `json:"protect_content,omitempty"`
+	LinkPreviewOptions  *tgmd.LinkPreviewOptions   `json:"link_preview_options,omitempty"`
+	ReplyMarkup         *tgmd.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+func (r Request) params(text string) sendMessageParams {
+	params := sendMessageParams{
+		ChatID:              r.ChatID,
+		MessageThreadID:     r.MessageThreadID,
+		Text:                text,
+		DisableNotification: r.DisableNotification,
+		ProtectContent:      r.ProtectContent,
+		LinkPreviewOptions:  r.LinkPreview,
+		ReplyMarkup:         r.ReplyMarkup,
+	}
+	if r.DisableLinkPreview {
+		params.LinkPreviewOptions = &tgmd.LinkPreviewOptions{IsDisabled: true}
+	}
+	return params
+}
+
+// chunk returns r for message i of n split messages, keeping the keyboard
+// for the last one.
+func (r Request) chunk(i, n int) Request {
+	if i < n-1 {
+		r.ReplyMarkup = nil
+	}
+	return r
+}
+
+// SendMarkdown converts source with tgmd, splits it into messages that fit
+// Telegram's limit and sends them in order. A message Telegram cannot parse
+// is sent again as text with entities converted from source. With
+// tgmd.WithFrontMatter, the front matter of source also sets the send
+// options of req, with tgmd.WithButtons the last message carries the
+// keyboard of source, and with tgmd.WithLinkPreview the message linking to
+// the chosen URL carries its preview while the others show none.
+func (c *Client) SendMarkdown(ctx context.Context, req Request, source []byte, opts ...tgmd.Option) (
+	[]*Message, error,
+) {
+	result, chunks, err := tgmd.SplitResult(source, tgmd.MessageLimit, opts...)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithFrontMatter(result.FrontMatter)
+	if result.Keyboard != nil {
+		req.ReplyMarkup = result.Keyboard
+	}
+	if result.LinkPreview != nil {
+		req.LinkPreview = result.LinkPreview
+	}
+	preview := previewChunk(chunks, req.LinkPreview)
+	sent := make([]*Message, 0, len(chunks))
+	for i, chunk := range chunks {
+		r := req.chunk(i, len(chunks))
+		if preview >= 0 && i != preview {
+			r.LinkPreview = &tgmd.LinkPreviewOptions{IsDisabled: true}
+		}
+		msg, err := c.sendChunk(ctx, r, chunk)
+		if err != nil {
+			return sent, err
+		}
+		sent = append(sent, msg)
+	}
+	return sent, nil
+}
+
+// previewChunk returns the index of the chunk linking to the URL the link
+// preview is about, the first one when none does, or -1 when preview names
+// no link. The other chunks are sent with their previews disabled.
+func previewChunk(chunks []tgmd.Chunk, preview *tgmd.LinkPreviewOptions) int {
+	if preview == nil || preview.IsDisabled || preview.URL == "" {
+		return -1
+	}
+	for i, chunk := range chunks {
+		entities := chunk.Entities
+		if chunk.Plain == "" {
+			_, entities, _ = tgmd.ParseMarkdownV2(chunk.Text)
+		}
+		for _, e := range entities {
+			if e.URL == preview.URL {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// sendChunk sends a message of a split document, falling back to its text
+// with entities when Telegram cannot parse it.
+func (c *Client) sendChunk(ctx context.Context, req Request, chunk tgmd.Chunk) (*Message, error) {
+	if chunk.Plain == "" {
+		return c.SendMarkdownV2(ctx, req, chunk.Text)
+	}
+	params := req.params(string(chunk.Text))
+	params.ParseMode = ParseModeMarkdownV2
+	msg, err := c.sendMessage(ctx, params)
+	if !IsParseError(err) {
+		return msg, err
+	}
+	return c.SendEntities(ctx, req, chunk.Plain, chunk.Entities)
+}
+
+// SendChunks sends MarkdownV2 messages in order, stopping at the first
+// one that fails. The messages sent so far are returned with the error.
+func (c *Client) SendChunks(ctx context.Context, req Request, chunks [][]byte) ([]*Message, error) {
+	sent := make([]*Message, 0, len(chunks))
+	for i, chunk := range chunks {
+		msg, err := c.SendMarkdownV2(ctx, req.chunk(i, len(chunks)), chunk)
+		if err != nil {
+			return sent, err
+		}
+		sent = append(sent, msg)
+	}
+	return sent, nil
+}
+
+// SendMarkdownV2 sends a MarkdownV2 message. If Telegram cannot parse it,
+// the message is decoded with tgmd.ParseMarkdownV2 and sent again as text
+// with entities, or as plain text when it cannot be decoded either. Use
+// SendMarkdown to fall back to the Markdown source instead.
+func (c *Client) SendMarkdownV2(ctx context.Context, req Request, text []byte) (*Message, error) {
+	params := req.params(string(text))
+	params.ParseMode = ParseModeMarkdownV2
+	msg, err := c.sendMessage(ctx, params)
+	if !IsParseError(err) {
+		return msg, err
+	}
+	plain, entities, parseErr := tgmd.ParseMarkdownV2(text)
+	if parseErr != nil {
+		plain, entities = string(text), nil
+	}
+	return c.SendEntities(ctx, req, plain, entities)
+}
+
+// SendEntities sends plain text formatted by entities, as returned by
+// tgmd.ConvertEntities.
+func (c *Client) SendEntities(ctx context.Context, req Request, text string, entities []tgmd.Entity) (
+	*Message, error,
+) {
+	params := req.params(text)
+	params.Entities = entities
+	return c.sendMessage(ctx, params)
+}
+
+func (c *Client) sendMessage(ctx context.Context, params sendMessageParams) (*Message, error) {
+	var msg Message
+	if err := c.call(ctx, "sendMessage", params, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// response is the envelope of every Bot API response.
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// call invokes method, retrying while Telegram asks to wait, and decodes
+// the result into result.
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, method, params, result)
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests || attempt >= c.maxRetries {
+			return err
+		}
+		if err := wait(ctx, time.Duration(apiErr.RetryAfter)*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps for d or until ctx is done.
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, params, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := c.baseURL + "/bot" + c.token + "/" + method
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		// The URL holds the token, keep it out of the error.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("tgsend: %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("tgsend: %s: %w", method, err)
+	}
+	var r response
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("tgsend: %s: unexpected response %s: %w", method, resp.Status, err)
+	}
+	if !r.OK {
+		return &Error{
+			Method:      method,
+			Code:        r.ErrorCode,
+			Description: r.Description,
+			RetryAfter:  r.Parameters.RetryAfter,
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
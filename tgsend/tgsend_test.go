@@ -0,0 +1,269 @@
+package tgsend_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+	"github.com/hentaiOS-Infrastructure/goldmark-tgmd/tgsend"
+	"github.com/hentaiOS-Infrastructure/goldmark-tgmd/tgsend/tgsendtest"
+)
+
+const token = "123:secret"
+
+func newClient(t *testing.T, opts ...tgsend.Option) (*tgsend.Client, *tgsendtest.Server) {
+	t.Helper()
+	server := tgsendtest.NewServer(token)
+	t.Cleanup(server.Close)
+	opts = append([]tgsend.Option{tgsend.WithBaseURL(server.URL)}, opts...)
+	return tgsend.New(token, opts...), server
+}
+
+func TestSendMarkdown_Splits(t *testing.T) {
+	client, server := newClient(t)
+	source := strings.Repeat("**Release** notes.\n\n", 400)
+
+	sent, err := client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "42", ProtectContent: true}, []byte(source))
+	if err != nil {
+		t.Fatalf("SendMarkdown failed: %v", err)
+	}
+	received := server.Messages()
+	if len(sent) < 2 || len(received) != len(sent) {
+		t.Fatalf("Expected several messages, sent %d, received %d", len(sent), len(received))
+	}
+	for i, msg := range received {
+		if msg.ParseMode != tgsend.ParseModeMarkdownV2 || msg.ChatID != "42" || !msg.ProtectContent {
+			t.Errorf("Message %d has wrong parameters: %+v", i, msg)
+		}
+		if sent[i].MessageID != msg.MessageID || sent[i].Chat.ID != 42 || sent[i].Text != msg.Text {
+			t.Errorf("Message %d result mismatch: %+v", i, sent[i])
+		}
+	}
+	if !strings.HasPrefix(received[0].Text, "Release notes.\n\nRelease") {
+		t.Errorf("Unexpected text: %q", received[0].Text)
+	}
+}
+
+func TestSendMarkdown_FrontMatter(t *testing.T) {
+	client, server := newClient(t)
+	source := "---\nmessage_thread_id: 7\ndisable_link_preview: true\nhashtags: [release]\n---\nOut now."
+
+	_, err := client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "42", ProtectContent: true},
+		[]byte(source), tgmd.WithFrontMatter())
+	if err != nil {
+		t.Fatalf("SendMarkdown failed: %v", err)
+	}
+	received := server.Messages()
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(received))
+	}
+	msg := received[0]
+	if msg.MessageThreadID != 7 || !msg.DisableLinkPreview || !msg.ProtectContent || msg.DisableNotification {
+		t.Errorf("Message has wrong parameters: %+v", msg)
+	}
+	if msg.Text != "Out now.\n\n#release\n" {
+		t.Errorf("Unexpected text: %q", msg.Text)
+	}
+
+	_, err = client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "42"},
+		[]byte("---\nprotect_content: maybe\n---\n"), tgmd.WithFrontMatter())
+	if err == nil || len(server.Messages()) != 1 {
+		t.Errorf("Expected invalid front matter to fail before sending, got %v", err)
+	}
+}
+
+func TestSendMarkdown_Buttons(t *testing.T) {
+	client, server := newClient(t)
+	source := strings.Repeat("Release notes.\n\n", 400) + "<!-- buttons -->\n- [Download](https://example.com/dl)\n"
+
+	_, err := client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "42"}, []byte(source), tgmd.WithButtons())
+	if err != nil {
+		t.Fatalf("SendMarkdown failed: %v", err)
+	}
+	received := server.Messages()
+	if len(received) < 2 {
+		t.Fatalf("Expected several messages, got %d", len(received))
+	}
+	for i, msg := range received[:len(received)-1] {
+		if msg.ReplyMarkup != nil {
+			t.Errorf("Message %d has a keyboard: %+v", i, msg.ReplyMarkup)
+		}
+	}
+	expected := &tgmd.InlineKeyboardMarkup{InlineKeyboard: [][]tgmd.InlineKeyboardButton{
+		{{Text: "Download", URL: "https://example.com/dl"}},
+	}}
+	if got := received[len(received)-1].ReplyMarkup; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Keyboard mismatch:\nExpected: %+v\nGot:      %+v", expected, got)
+	}
+
+	_, err = client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "42"},
+		[]byte("<!-- buttons -->\n- [Open](ftp://example.com)\n"), tgmd.WithButtons())
+	var keyboardErr *tgmd.KeyboardError
+	if !errors.As(err, &keyboardErr) {
+		t.Errorf("Expected an invalid keyboard to fail before sending, got %v", err)
+	}
+}
+
+func TestSendMarkdown_LinkPreview(t *testing.T) {
+	client, server := newClient(t)
+	notes := strings.Repeat("Release notes.\n\n", 400)
+	source := "See [the old build](https://example.com/dl/old).\n\n" + notes +
+		"Get [it](https://example.com/dl){preview}.\n\n" + notes
+	opts := []tgmd.Option{tgmd.WithLinkPreview(tgmd.LinkPreviewConfig{PreferLargeMedia: true}), tgmd.WithFrontMatter()}
+
+	if _, err := client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "1"}, []byte(source), opts...); err != nil {
+		t.Fatalf("SendMarkdown failed: %v", err)
+	}
+	expected := &tgmd.LinkPreviewOptions{URL: "https://example.com/dl", PreferLargeMedia: true}
+	previews := 0
+	for i, msg := range server.Messages() {
+		switch {
+		case strings.Contains(msg.Text, "Get it."):
+			previews++
+			if !reflect.DeepEqual(msg.LinkPreview, expected) {
+				t.Errorf("Message %d LinkPreview mismatch:\nExpected: %+v\nGot:      %+v", i, expected, msg.LinkPreview)
+			}
+		case !msg.DisableLinkPreview:
+			t.Errorf("Message %d without the link has a preview: %+v", i, msg.LinkPreview)
+		}
+	}
+	if previews != 1 {
+		t.Fatalf("Expected one message with the link, got %d", previews)
+	}
+
+	source = "---\ndisable_link_preview: true\n---\nGet [it](https://example.com/dl){preview}."
+	if _, err := client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "1"}, []byte(source), opts...); err != nil {
+		t.Fatalf("SendMarkdown failed: %v", err)
+	}
+	received := server.Messages()
+	if msg := received[len(received)-1]; !msg.DisableLinkPreview {
+		t.Errorf("Expected the front matter to disable the preview, got %+v", msg.LinkPreview)
+	}
+}
+
+func TestSendMarkdownV2_LinkPreview(t *testing.T) {
+	client, server := newClient(t)
+	result, err := tgmd.ConvertResult([]byte("Get [it](https://example.com/dl){preview}."),
+		tgmd.WithLinkPreview(tgmd.LinkPreviewConfig{PreferLargeMedia: true}))
+	if err != nil {
+		t.Fatalf("ConvertResult failed: %v", err)
+	}
+
+	req := tgsend.Request{ChatID: "1", LinkPreview: result.LinkPreview}
+	if _, err := client.SendMarkdownV2(context.Background(), req, result.Text); err != nil {
+		t.Fatalf("SendMarkdownV2 failed: %v", err)
+	}
+	expected := &tgmd.LinkPreviewOptions{URL: "https://example.com/dl", PreferLargeMedia: true}
+	if msg := server.Messages()[0]; !reflect.DeepEqual(msg.LinkPreview, expected) || msg.DisableLinkPreview {
+		t.Errorf("LinkPreview mismatch:\nExpected: %+v\nGot:      %+v", expected, msg.LinkPreview)
+	}
+}
+
+func TestSendMarkdownV2_RetriesRateLimit(t *testing.T) {
+	client, server := newClient(t)
+	server.RateLimit(2, 0)
+
+	if _, err := client.SendMarkdownV2(context.Background(), tgsend.Request{ChatID: "1"}, []byte("*hi*")); err != nil {
+		t.Fatalf("SendMarkdownV2 failed: %v", err)
+	}
+	if n := len(server.Messages()); n != 1 {
+		t.Errorf("Expected 1 message, got %d", n)
+	}
+}
+
+func TestSendMarkdownV2_GivesUpAfterRetries(t *testing.T) {
+	client, server := newClient(t, tgsend.WithMaxRetries(1))
+	server.RateLimit(2, 0)
+
+	_, err := client.SendMarkdownV2(context.Background(), tgsend.Request{ChatID: "1"}, []byte("*hi*"))
+	var apiErr *tgsend.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a 429 error, got %v", err)
+	}
+}
+
+func TestSendMarkdownV2_FallsBackToEntities(t *testing.T) {
+	client, server := newClient(t)
+	server.FailNext(tgsendtest.Error{
+		Code:        http.StatusBadRequest,
+		Description: "Bad Request: can't parse entities: Character '.' is reserved",
+	})
+
+	msg, err := client.SendMarkdownV2(context.Background(), tgsend.Request{ChatID: "1"}, []byte("*bold* \\."))
+	if err != nil {
+		t.Fatalf("SendMarkdownV2 failed: %v", err)
+	}
+	received := server.Messages()
+	if len(received) != 1 || received[0].ParseMode != "" {
+		t.Fatalf("Expected one message sent with entities, got %+v", received)
+	}
+	expected := []tgmd.Entity{{Type: tgmd.EntityBold, Offset: 0, Length: 4}}
+	if msg.Text != "bold ." || !reflect.DeepEqual(msg.Entities, expected) {
+		t.Errorf("Fallback mismatch: %q %+v", msg.Text, msg.Entities)
+	}
+}
+
+func TestSendMarkdown_FallsBackToSource(t *testing.T) {
+	client, server := newClient(t)
+	server.FailNext(tgsendtest.Error{
+		Code:        http.StatusBadRequest,
+		Description: "Bad Request: can't parse entities: Character '.' is reserved",
+	})
+	source := strings.Repeat("Version 1.2 is **out** (finally).\n\n", 200)
+
+	sent, err := client.SendMarkdown(context.Background(), tgsend.Request{ChatID: "1"}, []byte(source))
+	if err != nil {
+		t.Fatalf("SendMarkdown failed: %v", err)
+	}
+	received := server.Messages()
+	if len(received) != len(sent) || len(received) < 2 || received[0].ParseMode != "" {
+		t.Fatalf("Expected the first of several messages sent with entities, got %+v", received)
+	}
+	first := received[0]
+	if strings.Contains(first.Text, "\\") || !strings.HasPrefix(first.Text, "Version 1.2 is out (finally).\n\n") {
+		t.Errorf("Unexpected fallback text: %q", first.Text)
+	}
+	if received[1].ParseMode != tgsend.ParseModeMarkdownV2 {
+		t.Errorf("Expected the next message to be sent as MarkdownV2, got %+v", received[1])
+	}
+	expected := tgmd.Entity{Type: tgmd.EntityBold, Offset: 15, Length: 3}
+	if len(first.Entities) == 0 || first.Entities[0] != expected {
+		t.Errorf("Unexpected fallback entities: %+v", first.Entities)
+	}
+	last := first.Entities[len(first.Entities)-1]
+	if n := len(first.Text); last.Offset+last.Length > n {
+		t.Errorf("Entity %+v exceeds the text of %d characters", last, n)
+	}
+}
+
+func TestSendMarkdownV2_FallsBackToPlainText(t *testing.T) {
+	client, server := newClient(t)
+
+	msg, err := client.SendMarkdownV2(context.Background(), tgsend.Request{ChatID: "1"}, []byte("*unclosed."))
+	if err != nil {
+		t.Fatalf("SendMarkdownV2 failed: %v", err)
+	}
+	if msg.Text != "*unclosed." || len(msg.Entities) != 0 {
+		t.Errorf("Expected the raw text, got %q %+v", msg.Text, msg.Entities)
+	}
+	if n := len(server.Messages()); n != 1 {
+		t.Errorf("Expected 1 message, got %d", n)
+	}
+}
+
+func TestSendMarkdownV2_Errors(t *testing.T) {
+	client, _ := newClient(t)
+
+	_, err := client.SendMarkdownV2(context.Background(), tgsend.Request{}, []byte("hi"))
+	var apiErr *tgsend.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest || tgsend.IsParseError(err) {
+		t.Fatalf("Expected a bad request error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "chat not found") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+// Package tgsendtest provides a fake Bot API server for tests. It accepts
+// sendMessage requests, parses MarkdownV2 with tgmd the way Telegram does
+// and records the messages, so code sending messages can be tested offline.
+package tgsendtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+// Message is a message received by the server.
+type Message struct {
+	MessageID           int
+	ChatID              string
+	MessageThreadID     int
+	ParseMode           string
+	DisableNotification bool
+	ProtectContent      bool
+	DisableLinkPreview  bool
+	// LinkPreview is the link_preview_options of the message, or nil.
+	LinkPreview *tgmd.LinkPreviewOptions
+	// ReplyMarkup is the inline keyboard of the message, or nil.
+	ReplyMarkup *tgmd.InlineKeyboardMarkup
+	// Source is the text as it was sent, before parsing.
+	Source string
+	// Text and Entities are the message as Telegram would show it.
+	Text     string
+	Entities []tgmd.Entity
+}
+
+// Error is a response the server gives instead of handling a request.
+type Error struct {
+	Code        int
+	Description string
+	RetryAfter  int
+}
+
+// Server is a fake Bot API server.
+type Server struct {
+	*httptest.Server
+	token string
+
+	mu       sync.Mutex
+	messages []Message
+	failures []Error
+}
+
+// NewServer starts a server accepting requests for the bot with the given
+// token. Its URL is the base URL to give to clients.
+func NewServer(token string) *Server {
+	s := &Server{token: token}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// FailNext makes the server answer the next requests with errors, one
+// request each, e.g. a 429 Too Many Requests with a retry delay.
+func (s *Server) FailNext(errs ...Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, errs...)
+}
+
+// RateLimit makes the server refuse the next n requests with 429 Too Many
+// Requests asking to retry after retryAfter seconds.
+func (s *Server) RateLimit(n, retryAfter int) {
+	for range n {
+		s.FailNext(Error{
+			Code:        http.StatusTooManyRequests,
+			Description: "Too Many Requests: retry after " + strconv.Itoa(retryAfter),
+			RetryAfter:  retryAfter,
+		})
+	}
+}
+
+// sendMessageParams is the body of a sendMessage request.
+type sendMessageParams struct {
+	ChatID              json.RawMessage            `json:"chat_id"`
+	MessageThreadID     int                        `json:"message_thread_id"`
+	Text                string                     `json:"text"`
+	ParseMode           string                     `json:"parse_mode"`
+	Entities            []tgmd.Entity              `json:"entities"`
+	DisableNotification bool                       `json:"disable_notification"`
+	ProtectContent      bool                       `json:"protect_content"`
+	LinkPreviewOptions  *tgmd.LinkPreviewOptions   `json:"link_preview_options"`
+	ReplyMarkup         *tgmd.InlineKeyboardMarkup `json:"reply_markup"`
+}
+
+// messageLimit is the longest message text accepted, in UTF-16 code units.
+const messageLimit = 4096
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + s.token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, Error{Code: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+	if method := strings.TrimPrefix(r.URL.Path, prefix); method != "sendMessage" {
+		writeError(w, Error{Code: http.StatusNotFound, Description: "Not Found: method not found"})
+		return
+	}
+	if err := s.nextFailure(); err != nil {
+		writeError(w, *err)
+		return
+	}
+
+	var params sendMessageParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+	msg := Message{
+		ChatID:              strings.Trim(string(params.ChatID), `"`),
+		MessageThreadID:     params.MessageThreadID,
+		ParseMode:           params.ParseMode,
+		DisableNotification: params.DisableNotification,
+		ProtectContent:      params.ProtectContent,
+		DisableLinkPreview:  params.LinkPreviewOptions != nil && params.LinkPreviewOptions.IsDisabled,
+		LinkPreview:         params.LinkPreviewOptions,
+		ReplyMarkup:         params.ReplyMarkup,
+		Source:              params.Text,
+		Text:                params.Text,
+		Entities:            params.Entities,
+	}
+	switch params.ParseMode {
+	case "":
+	case "MarkdownV2":
+		text, entities, err := tgmd.ParseMarkdownV2([]byte(params.Text))
+		if err != nil {
+			writeError(w, Error{
+				Code:        http.StatusBadRequest,
+				Description: "Bad Request: can't parse entities: " + err.Error(),
+			})
+			return
+		}
+		msg.Text, msg.Entities = text, entities
+	default:
+		writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: unsupported parse_mode"})
+		return
+	}
+	switch n := len(utf16.Encode([]rune(msg.Text))); {
+	case msg.ChatID == "":
+		writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: chat not found"})
+		return
+	case strings.TrimSpace(msg.Text) == "":
+		writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: message text is empty"})
+		return
+	case n > messageLimit:
+		writeError(w, Error{Code: http.StatusBadRequest, Description: "Bad Request: message is too long"})
+		return
+	}
+
+	s.mu.Lock()
+	msg.MessageID = len(s.messages) + 1
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+
+	chatID, _ := strconv.ParseInt(msg.ChatID, 10, 64)
+	chat := map[string]any{"id": chatID}
+	if strings.HasPrefix(msg.ChatID, "@") {
+		chat["username"] = strings.TrimPrefix(msg.ChatID, "@")
+	}
+	writeResult(w, map[string]any{
+		"message_id": msg.MessageID,
+		"chat":       chat,
+		"date":       time.Now().Unix(),
+		"text":       msg.Text,
+		"entities":   msg.Entities,
+	})
+}
+
+func (s *Server) nextFailure() *Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) == 0 {
+		return nil
+	}
+	err := s.failures[0]
+	s.failures = s.failures[1:]
+	return &err
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, e Error) {
+	body := map[string]any{
+		"ok":          false,
+		"error_code":  e.Code,
+		"description": e.Description,
+	}
+	if e.RetryAfter > 0 {
+		body["parameters"] = map[string]any{"retry_after": e.RetryAfter}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	_ = json.NewEncoder(w).Encode(body)
+}
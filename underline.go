@@ -0,0 +1,206 @@
+package tgmd
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var KindUnderline = ast.NewNodeKind("Underline")
+
+// UnderlineAST abstract semantic tree for "underline".
+type UnderlineAST struct {
+	ast.BaseInline
+}
+
+// NewUnderline initialize UnderlineAST object.
+func NewUnderline() *UnderlineAST {
+	return &UnderlineAST{}
+}
+
+// Dump implements Node.Dump.
+func (n *UnderlineAST) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Kind implements Node.Kind.
+func (n *UnderlineAST) Kind() ast.NodeKind {
+	return KindUnderline
+}
+
+// UnderlineSyntax defines the source syntax of underlined text.
+type UnderlineSyntax int
+
+// define underline syntaxes.
+const (
+	// UnderlinePlus parses "++text++" as underline.
+	UnderlinePlus UnderlineSyntax = iota
+	// UnderlineUnderscore parses "__text__" as underline instead of bold,
+	// like Telegram does. "_text_" stays italic.
+	UnderlineUnderscore
+)
+
+// delimiter returns the character that delimits underlined text.
+func (s UnderlineSyntax) delimiter() byte {
+	if s == UnderlineUnderscore {
+		return UnderscoreChar.Byte()
+	}
+	return PlusChar.Byte()
+}
+
+type underlineDelimiterProcessor struct {
+	char byte
+}
+
+// IsDelimiter check incoming byte with object delimiter.
+func (p *underlineDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == p.char
+}
+
+// CanOpenCloser ...
+func (p *underlineDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+// OnMatch returns an underline for a pair of double delimiters. A single
+// underscore keeps its CommonMark meaning of italic.
+func (p *underlineDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	if consumes == 1 {
+		return ast.NewEmphasis(1)
+	}
+	return NewUnderline()
+}
+
+type underlineParser struct {
+	syntax    UnderlineSyntax
+	processor *underlineDelimiterProcessor
+}
+
+// NewUnderlineParser initialize parser.InlineParser for the given syntax.
+func NewUnderlineParser(syntax UnderlineSyntax) parser.InlineParser {
+	return &underlineParser{
+		syntax:    syntax,
+		processor: &underlineDelimiterProcessor{char: syntax.delimiter()},
+	}
+}
+
+// Trigger char for parser.
+func (s *underlineParser) Trigger() []byte {
+	return []byte{s.syntax.delimiter()}
+}
+
+// Parse source.
+func (s *underlineParser) Parse(_ ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	minimum := 2
+	if s.syntax == UnderlineUnderscore {
+		minimum = 1
+	}
+	node := parser.ScanDelimiter(line, before, minimum, s.processor)
+	if node == nil {
+		return nil
+	}
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+// CloseBlock ...
+func (s *underlineParser) CloseBlock(_ ast.Node, _ parser.Context) {
+	// nothing to do
+}
+
+type underline struct {
+	syntax UnderlineSyntax
+}
+
+// Underline parses "++text++" as underlined text.
+var Underline = &underline{syntax: UnderlinePlus}
+
+// NewUnderlineExtension returns an extension that parses underlined text
+// written with syntax.
+func NewUnderlineExtension(syntax UnderlineSyntax) goldmark.Extender {
+	return &underline{syntax: syntax}
+}
+
+// Extend ...
+func (e *underline) Extend(m goldmark.Markdown) {
+	// Run ahead of the emphasis parser, which would take "__" as bold.
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(NewUnderlineParser(e.syntax), 450),
+	))
+}
+
+// opensWithUnderscore reports whether the MarkdownV2 markup of n starts
+// with an underscore.
+func (c *config) opensWithUnderscore(source []byte, n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.RawHTML:
+		return c.isUnderscoreHTMLTag(source, n)
+	case *UnderlineAST:
+		return true
+	case *ast.Emphasis:
+		return n.Level == 1
+	case *ast.Heading:
+		e := c.headings[n.Level-1]
+		return e.Prefix == "" && len(e.Style) > 0 && e.Style[0] == UnderscoreChar
+	}
+	return false
+}
+
+// closesWithUnderscore reports whether the MarkdownV2 markup of n ends
+// with an underscore.
+func (c *config) closesWithUnderscore(source []byte, n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.RawHTML:
+		return c.isUnderscoreHTMLTag(source, n)
+	case *UnderlineAST:
+		return true
+	case *ast.Emphasis:
+		return n.Level == 1
+	case *ast.Heading:
+		e := c.headings[n.Level-1]
+		return e.Postfix == "" && len(e.Style) > 0 && e.Style[len(e.Style)-1] == UnderscoreChar
+	}
+	return false
+}
+
+// startsWithUnderscore reports whether the MarkdownV2 markup written for n
+// when entering or leaving it starts with an underscore.
+func (c *config) startsWithUnderscore(source []byte, n ast.Node, entering bool) bool {
+	if n, ok := n.(*ast.Heading); ok {
+		e := c.headings[n.Level-1]
+		return (entering || e.Postfix == "") && len(e.Style) > 0 && e.Style[0] == UnderscoreChar
+	}
+	return c.opensWithUnderscore(source, n)
+}
+
+// needsUnderscoreSeparator reports whether the underscore markup written
+// for n would touch another underscore markup. Telegram reads "__"
+// greedily as underline, so "___" has to be written as "_\r__".
+func (c *config) needsUnderscoreSeparator(source []byte, n ast.Node, entering bool) bool {
+	if !c.startsWithUnderscore(source, n, entering) {
+		return false
+	}
+	if !entering {
+		return n.LastChild() != nil && c.closesWithUnderscore(source, n.LastChild())
+	}
+	if prev := n.PreviousSibling(); prev != nil {
+		return c.closesWithUnderscore(source, prev)
+	}
+	return n.Parent() != nil && c.opensWithUnderscore(source, n.Parent())
+}
+
+// isUnderscoreHTMLTag reports whether n is translated to italic or
+// underline markup.
+func (c *config) isUnderscoreHTMLTag(source []byte, n *ast.RawHTML) bool {
+	if c.htmlPolicy != HTMLTranslate {
+		return false
+	}
+	tag, _, ok := translatedHTMLTag(source, n)
+	return ok && tag[0] == UnderscoreChar
+}
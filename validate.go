@@ -0,0 +1,125 @@
+package tgmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is a problem found in a MarkdownV2 message.
+type Diagnostic struct {
+	// Position is where the problem is in the message.
+	Position Position `json:"position"`
+	Message  string   `json:"message"`
+}
+
+// String formats d as "line:column: message".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Position.Line, d.Position.Column, d.Message)
+}
+
+// ValidationError is returned by Validate for a message Telegram would
+// reject.
+type ValidationError struct {
+	Diagnostics []Diagnostic
+}
+
+// Error implements error.
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("tgmd: invalid MarkdownV2: ")
+	b.WriteString(e.Diagnostics[0].String())
+	if more := len(e.Diagnostics) - 1; more > 0 {
+		fmt.Fprintf(&b, " (and %d more)", more)
+	}
+	return b.String()
+}
+
+// Validate reports whether text is a valid MarkdownV2 message. The
+// returned error is a *ValidationError listing every problem.
+func Validate(text []byte) error {
+	if diagnostics := Lint(text); len(diagnostics) > 0 {
+		return &ValidationError{Diagnostics: diagnostics}
+	}
+	return nil
+}
+
+// Lint checks text against the Telegram MarkdownV2 grammar: reserved
+// characters must be escaped, entities must be closed in the order they
+// were opened, links and quotes cannot be nested, "`" must be escaped in
+// pre blocks, and expandable quotes must end with "||".
+func Lint(text []byte) []Diagnostic {
+	var (
+		diagnostics []Diagnostic
+		stack       []mdv2Token
+		prev        mdv2Token
+	)
+	report := func(offset int, format string, args ...any) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Position: positionAt(text, offset),
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	isOpen := func(entities ...EntityType) bool {
+		for _, t := range stack {
+			for _, e := range entities {
+				if t.entity == e {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, t := range scanMarkdownV2(text) {
+		switch t.kind {
+		case mdv2Open:
+			if t.entity == EntityTextLink && isOpen(EntityTextLink) {
+				report(t.start, "links cannot be nested")
+			}
+			stack = append(stack, t)
+		case mdv2Close:
+			i := len(stack) - 1
+			for stack[i].entity != t.entity {
+				i--
+			}
+			open := stack[i]
+			if i < len(stack)-1 {
+				report(t.start, "%s entity is closed before %s entity opened inside it",
+					open.entity, stack[len(stack)-1].entity)
+			}
+			stack = append(stack[:i], stack[i+1:]...)
+			if t.start != t.end {
+				break
+			}
+			switch open.entity {
+			case EntityBlockquote:
+				// ends with its last line
+			case EntityExpandableBlockquote:
+				report(open.start, "expandable quote is not terminated with \"||\"")
+			default:
+				report(open.start, "%s entity is not closed", open.entity)
+			}
+		case mdv2Text:
+			c := text[t.start]
+			escaped := c == SlashChar.Byte() && t.end-t.start > 1
+			switch {
+			case c == SlashChar.Byte() && !escaped:
+				report(t.start, "'\\' at the end of the message escapes nothing")
+			case escaped || c == '\r':
+			case isOpen(EntityPre, EntityCode):
+				if c == BackqouteChar.Byte() {
+					report(t.start, "'`' must be escaped inside pre blocks")
+				}
+			case c == GreaterThanChar.Byte() && (prev.kind == mdv2QuoteMark || prev.kind == mdv2Open &&
+				(prev.entity == EntityBlockquote || prev.entity == EntityExpandableBlockquote)):
+				report(t.start, "quotes cannot be nested")
+			default:
+				if _, reserved := escape[c]; reserved {
+					report(t.start, "character %q is reserved and must be escaped", c)
+				}
+			}
+		}
+		prev = t
+	}
+	return diagnostics
+}
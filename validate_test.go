@@ -0,0 +1,116 @@
+package tgmd_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	tgmd "github.com/hentaiOS-Infrastructure/goldmark-tgmd"
+)
+
+func TestLint(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "Valid Message",
+			input: "*bold _italic_* __under__ ~strike~ ||spoiler|| `a\\`b` [link](https://example.com/\\)) a\\.b",
+		},
+		{
+			name:  "Valid Quotes and Pre",
+			input: ">quote\n>line\n\n**>expandable\n>last||\n```go\n*not bold* \\` \\\\\n```",
+		},
+		{
+			name:     "Unescaped Reserved Character",
+			input:    "Version 1.2",
+			expected: []string{"1:10: character '.' is reserved and must be escaped"},
+		},
+		{
+			name:     "Unclosed Entity",
+			input:    "plain\n*bold",
+			expected: []string{"2:1: bold entity is not closed"},
+		},
+		{
+			name:     "Crossed Entities",
+			input:    "*a _b* c_",
+			expected: []string{"1:6: bold entity is closed before italic entity opened inside it"},
+		},
+		{
+			name:     "Nested Links",
+			input:    "[a [b](x)](y)",
+			expected: []string{"1:4: links cannot be nested"},
+		},
+		{
+			name:  "Custom Emoji",
+			input: "![👍](tg://emoji?id=5368324170671202286) [a ![\\.](tg://emoji?id=1)](x)",
+		},
+		{
+			name:     "Image that is not a Custom Emoji",
+			input:    "![a](x)",
+			expected: []string{"1:1: character '!' is reserved and must be escaped"},
+		},
+		{
+			name:     "Unescaped Backquote in Pre",
+			input:    "```\na`b\n```",
+			expected: []string{"2:2: '`' must be escaped inside pre blocks"},
+		},
+		{
+			name:     "Unterminated Expandable Quote",
+			input:    "**>hidden\n>lines\nafter",
+			expected: []string{"1:1: expandable quote is not terminated with \"||\""},
+		},
+		{
+			name:     "Nested Quote",
+			input:    ">>inner",
+			expected: []string{"1:2: quotes cannot be nested"},
+		},
+		{
+			name:  "Nested Entity of the Same Kind",
+			input: "_a *b _c_ d* e_",
+			expected: []string{
+				"1:7: italic entity is closed before bold entity opened inside it",
+				"1:12: bold entity is closed before italic entity opened inside it",
+			},
+		},
+		{
+			name:     "Quote Marker inside Text",
+			input:    "a > b",
+			expected: []string{"1:3: character '>' is reserved and must be escaped"},
+		},
+		{
+			name:     "Dangling Backslash",
+			input:    "end\\",
+			expected: []string{"1:4: '\\' at the end of the message escapes nothing"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, d := range tgmd.Lint([]byte(tc.input)) {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Diagnostics mismatch:\nInput:    %q\nExpected: %q\nGot:      %q", tc.input, tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := tgmd.Validate([]byte("fine\\!")); err != nil {
+		t.Errorf("Validate failed on a valid message: %v", err)
+	}
+
+	err := tgmd.Validate([]byte("a.b.c"))
+	var verr *tgmd.ValidationError
+	if !errors.As(err, &verr) || len(verr.Diagnostics) != 2 {
+		t.Fatalf("Expected a ValidationError with 2 diagnostics, got %v", err)
+	}
+	expected := "tgmd: invalid MarkdownV2: 1:2: character '.' is reserved and must be escaped (and 1 more)"
+	if err.Error() != expected {
+		t.Errorf("Error mismatch:\nExpected: %q\nGot:      %q", expected, err.Error())
+	}
+}
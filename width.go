@@ -0,0 +1,79 @@
+package tgmd
+
+import "unicode"
+
+// wideRanges lists East Asian wide and fullwidth characters as well as
+// emoji, which take two columns in a monospace font.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x231A, 0x231B},   // watch, hourglass
+	{0x23E9, 0x23F3},   // media control symbols
+	{0x25FD, 0x25FE},   // small squares
+	{0x2614, 0x2615},   // umbrella, hot beverage
+	{0x2648, 0x2653},   // zodiac
+	{0x26AA, 0x26AB},   // circles
+	{0x26BD, 0x26BE},   // soccer, baseball
+	{0x26C4, 0x26C5},   // snowman, sun
+	{0x26F2, 0x26FA},   // fountain ... tent
+	{0x2705, 0x2705},   // check mark button
+	{0x270A, 0x270B},   // fists
+	{0x2728, 0x2728},   // sparkles
+	{0x274C, 0x274C},   // cross mark
+	{0x2753, 0x2757},   // question and exclamation marks
+	{0x2795, 0x2797},   // plus, minus, divide
+	{0x2B1B, 0x2B1C},   // large squares
+	{0x2B50, 0x2B55},   // star, circle
+	{0x2E80, 0x303E},   // CJK radicals ... CJK symbols
+	{0x3041, 0x33FF},   // Hiragana ... CJK compatibility
+	{0x3400, 0x4DBF},   // CJK extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE30, 0xFE4F},   // CJK compatibility forms
+	{0xFF00, 0xFF60},   // fullwidth forms
+	{0xFFE0, 0xFFE6},   // fullwidth signs
+	{0x1F004, 0x1F004}, // mahjong tile
+	{0x1F0CF, 0x1F0CF}, // playing card
+	{0x1F18E, 0x1F18E}, // AB button
+	{0x1F191, 0x1F19A}, // squared words
+	{0x1F200, 0x1F2FF}, // enclosed ideographic supplement
+	{0x1F300, 0x1F64F}, // pictographs, emoticons
+	{0x1F680, 0x1F6FF}, // transport and map symbols
+	{0x1F7E0, 0x1F7EB}, // colored circles and squares
+	{0x1F90C, 0x1F9FF}, // supplemental symbols and pictographs
+	{0x1FA70, 0x1FAFF}, // symbols and pictographs extended-A
+	{0x20000, 0x3FFFD}, // CJK extensions B and later
+}
+
+// runeWidth returns the number of monospace columns r takes.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0x200D, // zero width joiner
+		r >= 0xFE00 && r <= 0xFE0F,   // variation selectors
+		r >= 0xE0100 && r <= 0xE01EF, // variation selectors supplement
+		r >= 0x1F3FB && r <= 0x1F3FF, // skin tone modifiers
+		unicode.Is(unicode.Mn, r),
+		unicode.Is(unicode.Me, r),
+		unicode.Is(unicode.Cf, r):
+		return 0
+	}
+	for _, rng := range wideRanges {
+		if r < rng[0] {
+			break
+		}
+		if r <= rng[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// displayWidth returns the number of monospace columns s takes.
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
@@ -1,53 +1,130 @@
 package tgmd
 
 import (
-	"os"
+	"errors"
+	"fmt"
 
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/util"
 )
 
-func writeSpecialTagStart(w util.BufWriter, tag SpecialTag, prefix []byte) {
-	writeWrapperArr(w.Write(tag.Bytes()))
-	writeCustomBytes(w, prefix)
+// RenderError is returned when writing the output of a node fails, e.g.
+// because the io.Writer refuses more data. Output is buffered, so the node
+// is the one being written when the buffer could not be flushed; an error
+// flushing the end of the output is returned as is.
+type RenderError struct {
+	// Kind is the kind of the node being written.
+	Kind ast.NodeKind
+	// Position is where the node starts in the source.
+	Position Position
+	// Err is the error returned by the writer.
+	Err error
 }
 
-func writeSpecialTagEnd(w util.BufWriter, tag SpecialTag, postfix []byte) {
-	writeCustomBytes(w, postfix)
-	writeWrapperArr(w.Write(tag.Bytes()))
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("tgmd: rendering %s at %d:%d: %v", e.Kind, e.Position.Line, e.Position.Column, e.Err)
 }
 
-func writeNewLine(w util.BufWriter) {
-	writeCustomBytes(w, []byte{NewLineChar.Byte()})
+func (e *RenderError) Unwrap() error {
+	return e.Err
 }
 
-func render(w util.BufWriter, b []byte) {
-	writeCustomBytes(w, b)
+// errorReporter registers node renderer functions so that the errors they
+// return are reported as *RenderError.
+type errorReporter struct {
+	renderer.NodeRendererFuncRegisterer
 }
 
-func writeRune(w util.BufWriter, data rune) {
-	writeWrapperArr(w.WriteRune(data))
+// Register implements renderer.NodeRendererFuncRegisterer.
+func (r errorReporter) Register(kind ast.NodeKind, f renderer.NodeRendererFunc) {
+	r.NodeRendererFuncRegisterer.Register(kind, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (
+		ast.WalkStatus, error,
+	) {
+		status, err := f(w, source, n, entering)
+		if err == nil {
+			return status, nil
+		}
+		// Errors of nodes rendered on their own already tell which one.
+		var renderErr *RenderError
+		if errors.As(err, &renderErr) {
+			return ast.WalkStop, err
+		}
+		return ast.WalkStop, &RenderError{
+			Kind:     n.Kind(),
+			Position: positionAt(source, nodeOffset(source, n)),
+			Err:      err,
+		}
+	})
+}
+
+func writeSpecialTagStart(w util.BufWriter, tag SpecialTag, prefix []byte) error {
+	if err := writeRowBytes(w, tag.Bytes()); err != nil {
+		return err
+	}
+	return writeCustomBytes(w, prefix)
+}
+
+func writeSpecialTagEnd(w util.BufWriter, tag SpecialTag, postfix []byte) error {
+	if err := writeCustomBytes(w, postfix); err != nil {
+		return err
+	}
+	return writeRowBytes(w, tag.Bytes())
+}
+
+func writeNewLine(w util.BufWriter) error {
+	return writeNewLines(w, 1)
 }
 
-func writeRowBytes(w util.BufWriter, data []byte) {
-	writeWrapperArr(w.Write(data))
+func writeNewLines(w util.BufWriter, count int) error {
+	return writeRowBytes(w, NewLineChar.Bytes(count))
 }
 
-func writeCustomBytes(w util.BufWriter, data []byte) {
+func render(w util.BufWriter, b []byte) error {
+	return writeCustomBytes(w, b)
+}
+
+func writeRowBytes(w util.BufWriter, data []byte) error {
+	_, err := w.Write(data)
+	return err
+}
+
+func writeCustomBytes(w util.BufWriter, data []byte) error {
+	return writeEscapedBytes(w, data, escape)
+}
+
+func writeCodeBytes(w util.BufWriter, data []byte) error {
+	return writeEscapedBytes(w, data, codeEscape)
+}
+
+func writeLinkBytes(w util.BufWriter, data []byte) error {
+	return writeEscapedBytes(w, data, linkEscape)
+}
+
+func writeEscapedBytes(w util.BufWriter, data []byte, table map[byte][]byte) error {
 	for _, char := range data {
-		if escaped, ok := escape[char]; ok {
-			writeWrapperArr(w.Write(escaped))
+		if escaped, ok := table[char]; ok {
+			if _, err := w.Write(escaped); err != nil {
+				return err
+			}
 			continue
 		}
-		writeWrapper(w.WriteByte(char))
+		if err := w.WriteByte(char); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func writeWrapperArr(_ int, err error) {
-	writeWrapper(err)
-}
-
-func writeWrapper(err error) {
-	if err != nil {
-		_, _ = os.Stderr.WriteString(err.Error())
+// escapeBytes returns data with the characters in table escaped.
+func escapeBytes(data []byte, table map[byte][]byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, char := range data {
+		if escaped, ok := table[char]; ok {
+			out = append(out, escaped...)
+			continue
+		}
+		out = append(out, char)
 	}
+	return out
 }
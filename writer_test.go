@@ -2,6 +2,8 @@ package tgmd
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/yuin/goldmark/util" // Keep for util.BufWriter interface
@@ -43,9 +45,50 @@ func TestWriteCustomBytes_RawEscaping(t *testing.T) {
 	b := &bytes.Buffer{}
 	writer := &testBufWriter{Buffer: b}
 
-	writeCustomBytes(writer, input)
+	if err := writeCustomBytes(writer, input); err != nil {
+		t.Fatalf("writeCustomBytes failed: %v", err)
+	}
 
 	if writer.String() != expected { // Use writer.String() which delegates to b.String()
 		t.Errorf("Output mismatch for writeCustomBytes:\nExpected: %q\nGot:      %q", expected, writer.String())
 	}
 }
+
+var errFull = errors.New("writer is full")
+
+// cappedWriter accepts up to limit bytes and fails after that.
+type cappedWriter struct {
+	limit int
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errFull
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestRender_WriteError(t *testing.T) {
+	source := []byte(strings.Repeat("Some **bold** text.\n\n", 300) + "# Heading\n")
+	for name, convert := range map[string]func([]byte, *cappedWriter) error{
+		"MarkdownV2": func(source []byte, w *cappedWriter) error { return TGMD().Convert(source, w) },
+		"HTML":       func(source []byte, w *cappedWriter) error { return TGHTML().Convert(source, w) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := convert(source, &cappedWriter{limit: 100})
+			if !errors.Is(err, errFull) {
+				t.Fatalf("Expected the writer error, got %v", err)
+			}
+			var renderErr *RenderError
+			if !errors.As(err, &renderErr) {
+				t.Fatalf("Expected a *RenderError, got %T", err)
+			}
+			if renderErr.Kind.String() == "" || renderErr.Position.Line < 1 || renderErr.Position.Line > 601 {
+				t.Errorf("Unexpected error location: %v", renderErr)
+			}
+		})
+	}
+}